@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/abner-tech/Test3-Api.git/internal/data"
+	"github.com/abner-tech/Test3-Api.git/internal/validator"
+)
+
+// create a personal api key limited to some of the permissions of the user
+func (a *applicationDependences) createAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	user := a.contextGetUser(r)
+
+	var incomingData struct {
+		Name          string   `json:"name"`
+		Permissions   []string `json:"permissions"`
+		ExpiresInDays int      `json:"expires_in_days"` //0 for a key that does not expire
+	}
+
+	err := a.readJSON(w, r, &incomingData)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	key := &data.APIKey{
+		Name:        incomingData.Name,
+		Permissions: data.Permissions(incomingData.Permissions),
+	}
+
+	v := validator.New()
+	data.ValidateAPIKey(v, key)
+	v.Check(incomingData.ExpiresInDays >= 0, "expires_in_days", "must not be negative")
+	v.Check(incomingData.ExpiresInDays <= 3650, "expires_in_days", "must not be more than 3650 days")
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	//a key can never do more than its owner
+	for _, code := range key.Permissions {
+		if !a.contextGetPermissions(r).Include(code) {
+			v.AddError("permissions", fmt.Sprintf("you do not hold the permission %s", code))
+			a.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+	}
+
+	ttl := time.Duration(incomingData.ExpiresInDays) * 24 * time.Hour
+	key, err = a.apiKeyModel.New(user.ID, key.Name, key.Permissions, ttl)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/api/v1/users/me/api-keys/%d", key.ID))
+
+	data := envelope{
+		"api_key": key,
+		"message": "store the key now, it will not be shown again",
+	}
+
+	err = a.writeJSON(w, http.StatusCreated, data, headers)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// list the api keys of the user, the keys themselves are never shown again
+func (a *applicationDependences) listAPIKeysHandler(w http.ResponseWriter, r *http.Request) {
+	keys, err := a.apiKeyModel.GetAllForUser(a.contextGetUser(r).ID)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	data := envelope{
+		"api_keys": keys,
+	}
+
+	err = a.writeJSON(w, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// revoke one of the api keys of the user
+func (a *applicationDependences) deleteAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := a.readIDParam(r, "key_id")
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	err = a.apiKeyModel.Delete(id, a.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	data := envelope{
+		"message": "api key revoked",
+	}
+
+	err = a.writeJSON(w, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
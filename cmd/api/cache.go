@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"sync"
+	"time"
+
+	"github.com/abner-tech/Test3-Api.git/internal/data"
+)
+
+// a cached authentication result for a single token
+type authCacheEntry struct {
+	user        data.User
+	permissions data.Permissions
+	expires     time.Time
+	touched     time.Time //when the token's last_used_at was last written
+}
+
+/*
+In-process cache of the user and permissions behind an authentication token,
+keyed by the token hash so plaintext tokens are never kept in memory.
+A ttl of zero disables the cache. Entries must be invalidated whenever the
+permissions, roles or tokens of a user change
+*/
+type authCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[[sha256.Size]byte]*authCacheEntry
+}
+
+func newAuthCache(ttl time.Duration) *authCache {
+	return &authCache{
+		ttl:     ttl,
+		entries: make(map[[sha256.Size]byte]*authCacheEntry),
+	}
+}
+
+// remove expired entries from the map every interval until ctx is cancelled
+func (c *authCache) startCleanup(ctx context.Context, interval time.Duration) {
+	if !c.enabled() {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			c.mu.Lock()
+			for hash, entry := range c.entries {
+				if time.Now().After(entry.expires) {
+					delete(c.entries, hash)
+				}
+			}
+			c.mu.Unlock()
+		}
+	}()
+}
+
+func (c *authCache) enabled() bool {
+	return c != nil && c.ttl > 0
+}
+
+// look up the user and permissions for a token, we hand out a copy of the user
+// so handlers changing it do not change the cached value
+func (c *authCache) Get(tokenPlainText string) (*data.User, data.Permissions, bool) {
+	if !c.enabled() {
+		return nil, nil, false
+	}
+
+	hash := sha256.Sum256([]byte(tokenPlainText))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, found := c.entries[hash]
+	if !found {
+		return nil, nil, false
+	}
+	if time.Now().After(entry.expires) {
+		delete(c.entries, hash)
+		return nil, nil, false
+	}
+
+	user := entry.user
+	return &user, entry.permissions, true
+}
+
+// cache the result for a token, the entry never outlives the token itself
+func (c *authCache) Set(tokenPlainText string, user *data.User, permissions data.Permissions, tokenExpiry time.Time) {
+	if !c.enabled() {
+		return
+	}
+
+	expires := time.Now().Add(c.ttl)
+	if tokenExpiry.Before(expires) {
+		expires = tokenExpiry
+	}
+
+	hash := sha256.Sum256([]byte(tokenPlainText))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries[hash] = &authCacheEntry{
+		user:        *user,
+		permissions: permissions,
+		expires:     expires,
+	}
+}
+
+// report whether the token's last use should be written to the database, at most once per
+// data.LastUsedResolution for a cached token. Without a cache entry we cannot tell, so the answer is yes
+func (c *authCache) TouchDue(tokenPlainText string) bool {
+	if !c.enabled() {
+		return true
+	}
+
+	hash := sha256.Sum256([]byte(tokenPlainText))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, found := c.entries[hash]
+	if !found {
+		return true
+	}
+	if time.Since(entry.touched) < data.LastUsedResolution {
+		return false
+	}
+	entry.touched = time.Now()
+	return true
+}
+
+// invalidate every token of a user, used when the user, their permissions or their tokens change
+func (c *authCache) DeleteUser(userID int64) {
+	if !c.enabled() {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for hash, entry := range c.entries {
+		if entry.user.ID == userID {
+			delete(c.entries, hash)
+		}
+	}
+}
+
+// invalidate everything, used when a change can affect many users (e.g. role permissions)
+func (c *authCache) Clear() {
+	if !c.enabled() {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	clear(c.entries)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/abner-tech/Test3-Api.git/internal/data"
+)
+
+func TestAuthCacheEntryEndsWithToken(t *testing.T) {
+	cache := newAuthCache(time.Hour)
+	user := &data.User{ID: 1}
+
+	//a token expiring before the cache ttl must not be served from the cache afterwards
+	cache.Set("expired", user, data.Permissions{}, time.Now().Add(-time.Second))
+	_, _, found := cache.Get("expired")
+	if found {
+		t.Error("got a cache hit for an expired token")
+	}
+
+	cache.Set("valid", user, data.Permissions{}, time.Now().Add(time.Minute))
+	_, _, found = cache.Get("valid")
+	if !found {
+		t.Error("got a cache miss for a valid token")
+	}
+}
+
+func TestAuthCacheDeleteUser(t *testing.T) {
+	cache := newAuthCache(time.Hour)
+	expiry := time.Now().Add(time.Hour)
+
+	cache.Set("first", &data.User{ID: 1}, data.Permissions{}, expiry)
+	cache.Set("second", &data.User{ID: 1}, data.Permissions{}, expiry)
+	cache.Set("other", &data.User{ID: 2}, data.Permissions{}, expiry)
+
+	cache.DeleteUser(1)
+
+	for _, token := range []string{"first", "second"} {
+		_, _, found := cache.Get(token)
+		if found {
+			t.Errorf("token %q of the deleted user is still cached", token)
+		}
+	}
+	_, _, found := cache.Get("other")
+	if !found {
+		t.Error("token of another user was evicted")
+	}
+}
+
+func TestAuthCacheTouchDue(t *testing.T) {
+	cache := newAuthCache(time.Hour)
+	cache.Set("token", &data.User{ID: 1}, data.Permissions{}, time.Now().Add(time.Hour))
+
+	//the first request after caching writes last_used_at, the ones right after it do not
+	if !cache.TouchDue("token") {
+		t.Error("first touch: got false; want true")
+	}
+	if cache.TouchDue("token") {
+		t.Error("second touch: got true; want false")
+	}
+	if !cache.TouchDue("unknown") {
+		t.Error("uncached token: got false; want true")
+	}
+}
@@ -28,16 +28,20 @@ also uses the key 'userContextKey' as the name of its key.
 */
 
 const userContextKey = contextKey("user")
+const permissionsContextKey = contextKey("permissions")
+const apiKeyContextKey = contextKey("api_key")
 
 /*
-Update the request context with the user information
+Update the request context with the user information and the user's
+permissions, so they are only loaded once per request
 We return the request context with user-info added
 */
-func (a *applicationDependences) contextSetUser(r *http.Request, user *data.User) *http.Request {
+func (a *applicationDependences) contextSetUser(r *http.Request, user *data.User, permissions data.Permissions) *http.Request {
 	// WithValue() expects the original context along with the new
 	// key:value pair you want to update it with
 
 	ctx := context.WithValue(r.Context(), userContextKey, user)
+	ctx = context.WithValue(ctx, permissionsContextKey, permissions)
 	return r.WithContext(ctx)
 }
 
@@ -56,3 +60,29 @@ func (a *applicationDependences) contextGetUser(r *http.Request) *data.User {
 
 	return user
 }
+
+/*
+Retrieve the permissions that were loaded alongside the user
+Anonymous users have no permissions so we get an empty slice
+*/
+func (a *applicationDependences) contextGetPermissions(r *http.Request) data.Permissions {
+	permissions, ok := r.Context().Value(permissionsContextKey).(data.Permissions)
+
+	if !ok {
+		panic("missing permissions value in request context")
+	}
+
+	return permissions
+}
+
+// remember the api key the request was authenticated with
+func (a *applicationDependences) contextSetAPIKey(r *http.Request, key *data.APIKey) *http.Request {
+	ctx := context.WithValue(r.Context(), apiKeyContextKey, key)
+	return r.WithContext(ctx)
+}
+
+// the api key the request was authenticated with, nil for bearer tokens and anonymous users
+func (a *applicationDependences) contextGetAPIKey(r *http.Request) *data.APIKey {
+	key, _ := r.Context().Value(apiKeyContextKey).(*data.APIKey)
+	return key
+}
@@ -65,6 +65,24 @@ func (a *applicationDependences) editConflictResponse(w http.ResponseWriter, r *
 	a.errorResponseJSON(w, r, http.StatusConflict, message)
 }
 
+// the user already reviewed the book, status 409
+func (a *applicationDependences) duplicateReviewResponse(w http.ResponseWriter, r *http.Request, bookID int64) {
+	message := fmt.Sprintf("you have already reviewed this book, change your review with PUT /api/v1/books/%d/reviews/me", bookID)
+	a.errorResponseJSON(w, r, http.StatusConflict, message)
+}
+
+// users may not vote for their own reviews, status 403
+func (a *applicationDependences) ownReviewVoteResponse(w http.ResponseWriter, r *http.Request) {
+	message := "you cannot vote for your own review"
+	a.errorResponseJSON(w, r, http.StatusForbidden, message)
+}
+
+// the user already has an open report for the review, status 409
+func (a *applicationDependences) duplicateReportResponse(w http.ResponseWriter, r *http.Request) {
+	message := "you have already reported this review, a moderator will look at it"
+	a.errorResponseJSON(w, r, http.StatusConflict, message)
+}
+
 // return 404 unauthorized status code
 func (a *applicationDependences) invalidCredentialResponse(w http.ResponseWriter, r *http.Request) {
 	message := "invalid authentication response"
@@ -98,3 +116,18 @@ func (a *applicationDependences) notPermittedResponse(w http.ResponseWriter, r *
 	a.errorResponseJSON(w, r, http.StatusForbidden, message)
 }
 
+// too many failed logins, the Retry-After header says when to try again
+func (a *applicationDependences) accountLockedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "too many failed login attempts, please try again later"
+	a.errorResponseJSON(w, r, http.StatusTooManyRequests, message)
+}
+
+func (a *applicationDependences) invalidAPIKeyResponse(w http.ResponseWriter, r *http.Request) {
+	message := "invalid, expired or revoked api key"
+	a.errorResponseJSON(w, r, http.StatusUnauthorized, message)
+}
+
+func (a *applicationDependences) apiKeyNotAllowedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "this action needs you to log in, it cannot be done with an api key"
+	a.errorResponseJSON(w, r, http.StatusForbidden, message)
+}
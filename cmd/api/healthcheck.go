@@ -12,6 +12,7 @@ func (a *applicationDependences) healthChechHandler(w http.ResponseWriter, r *ht
 			"environment": a.config.environment,
 			"version":     appVersion,
 		},
+		"token_janitor": a.tokenJanitor.snapshot(),
 	}
 
 	err := a.writeJSON(w, http.StatusOK, data, nil)
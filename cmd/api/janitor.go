@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// what the expired token janitor has done so far, shown on the healthcheck
+type tokenJanitorStats struct {
+	mu           sync.Mutex
+	lastRun      time.Time
+	lastRemoved  int64
+	totalRemoved int64
+}
+
+func (s *tokenJanitorStats) record(removed int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastRun = time.Now()
+	s.lastRemoved = removed
+	s.totalRemoved += removed
+}
+
+func (s *tokenJanitorStats) snapshot() map[string]any {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return map[string]any{
+		"last_run":         s.lastRun,
+		"removed_last_run": s.lastRemoved,
+		"removed_total":    s.totalRemoved,
+	}
+}
+
+// delete expired tokens in batches until none are left or ctx is cancelled
+func (a *applicationDependences) purgeExpiredTokens(ctx context.Context) (int64, error) {
+	return a.purgeInBatches(ctx, a.tokenModel.DeleteExpired)
+}
+
+// delete the accounts whose deletion grace period is over, in batches like the tokens
+func (a *applicationDependences) purgeDeletedAccounts(ctx context.Context) (int64, error) {
+	return a.purgeInBatches(ctx, a.userModel.DeleteScheduled)
+}
+
+// call purge with the janitor batch size until a short batch says we have caught up or ctx is cancelled
+func (a *applicationDependences) purgeInBatches(ctx context.Context, purge func(now time.Time, batchSize int) (int64, error)) (int64, error) {
+	now := time.Now()
+	batchSize := a.config.tokenJanitor.batchSize
+
+	var total int64
+	for ctx.Err() == nil {
+		removed, err := purge(now, batchSize)
+		if err != nil {
+			return total, err
+		}
+		total += removed
+
+		if removed < int64(batchSize) {
+			break
+		}
+	}
+	return total, nil
+}
+
+/*
+periodically purge expired tokens (and expired oidc logins) in the background. The janitor is tracked
+by the wait group so a graceful shutdown waits for the current batch to finish,
+and it stops once ctx is cancelled
+*/
+func (a *applicationDependences) startTokenJanitor(ctx context.Context) {
+	a.runEvery(ctx, a.config.tokenJanitor.interval, func() {
+		removed, err := a.purgeExpiredTokens(ctx)
+		if err != nil {
+			a.logger.Error(err.Error(), "task", "token janitor")
+		} else {
+			a.tokenJanitor.record(removed)
+			a.logger.Info("expired tokens purged", "removed", removed)
+		}
+
+		_, err = a.identityModel.DeleteExpiredPendingLogins(time.Now())
+		if err != nil {
+			a.logger.Error(err.Error(), "task", "oidc login janitor")
+		}
+	})
+}
+
+// periodically delete the accounts whose deletion grace period is over, on its own schedule
+func (a *applicationDependences) startAccountJanitor(ctx context.Context) {
+	a.runEvery(ctx, a.config.account.purgeInterval, func() {
+		removed, err := a.purgeDeletedAccounts(ctx)
+		if err != nil {
+			a.logger.Error(err.Error(), "task", "account janitor")
+		} else if removed > 0 {
+			a.logger.Info("deleted accounts purged", "removed", removed)
+		}
+	})
+}
+
+// run task now and then every interval until ctx is cancelled, an interval <= 0 never runs it
+func (a *applicationDependences) runEvery(ctx context.Context, interval time.Duration, task func()) {
+	if interval <= 0 {
+		return
+	}
+
+	a.wg.Add(1)
+	go func() {
+		defer a.wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			task()
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+/*
+rate limiter keyed by something other than the client ip, e.g. an email
+address, so one account cannot be hammered from many addresses. Works like
+rateLimiting: one token bucket per key, stale keys are removed in the background
+*/
+type keyedLimiter struct {
+	mu      sync.Mutex
+	every   time.Duration
+	burst   int
+	clients map[string]*keyedClient
+}
+
+type keyedClient struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// allow burst events per key, refilling one event every 'every'
+func newKeyedLimiter(every time.Duration, burst int) *keyedLimiter {
+	l := &keyedLimiter{
+		every:   every,
+		burst:   burst,
+		clients: make(map[string]*keyedClient),
+	}
+
+	//a gorutine to remove stale entries from the map
+	go func() {
+		for {
+			time.Sleep(time.Minute)
+			l.mu.Lock()
+			//once a bucket has had time to refill completely we can forget it
+			for key, client := range l.clients {
+				if time.Since(client.lastSeen) > l.every*time.Duration(l.burst)+time.Minute {
+					delete(l.clients, key)
+				}
+			}
+			l.mu.Unlock()
+		}
+	}()
+	return l
+}
+
+// check if another event is allowed for the key, keys are case insensitive
+func (l *keyedLimiter) Allow(key string) bool {
+	key = strings.ToLower(key)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	client, found := l.clients[key]
+	if !found {
+		client = &keyedClient{limiter: rate.NewLimiter(rate.Every(l.every), l.burst)}
+		l.clients[key] = client
+	}
+	client.lastSeen = time.Now()
+
+	return client.limiter.Allow()
+}
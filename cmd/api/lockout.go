@@ -0,0 +1,168 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/abner-tech/Test3-Api.git/internal/data"
+)
+
+/*
+Per-account brute-force protection for logins. The first few failures are
+free, after that every failure makes the account wait before the next attempt,
+doubling each time, and once the threshold is reached the account is locked
+for the lockout duration and its owner gets an email
+*/
+
+// refuse the login while the account is waiting or locked, reports whether it did
+func (a *applicationDependences) loginLocked(w http.ResponseWriter, r *http.Request, user *data.User) bool {
+	if user.LockedUntil == nil {
+		return false
+	}
+	return a.refuseUntil(w, r, *user.LockedUntil)
+}
+
+// answer 429 with a Retry-After header while until is in the future, reports whether it did
+func (a *applicationDependences) refuseUntil(w http.ResponseWriter, r *http.Request, until time.Time) bool {
+	wait := time.Until(until)
+	if wait <= 0 {
+		return false
+	}
+
+	//round up so clients never retry a moment too early
+	w.Header().Set("Retry-After", strconv.Itoa(int((wait+time.Second-1)/time.Second)))
+	a.accountLockedResponse(w, r)
+	return true
+}
+
+// how long logins are refused after the given number of failures, and whether that is a full lockout
+func (a *applicationDependences) lockoutDelay(failures int) (time.Duration, bool) {
+	settings := a.config.lockout
+
+	switch {
+	case settings.threshold > 0 && failures >= settings.threshold:
+		return settings.duration, true
+	case failures > settings.freeAttempts && settings.delay > 0:
+		//double the wait for every failure past the free ones, never beyond a full lockout
+		delay := settings.delay << min(failures-settings.freeAttempts-1, 20)
+		if settings.duration > 0 {
+			delay = min(delay, settings.duration)
+		}
+		return delay, false
+	}
+	return 0, false
+}
+
+// count a failed login and delay or lock the account when needed
+func (a *applicationDependences) recordFailedLogin(user *data.User) error {
+	failures, err := a.userModel.RecordFailedLogin(user.ID)
+	if err != nil {
+		return err
+	}
+
+	delay, lock := a.lockoutDelay(failures)
+	if delay <= 0 {
+		return nil
+	}
+
+	until := time.Now().Add(delay)
+	err = a.userModel.LockUntil(user.ID, until, lock)
+	if err != nil {
+		return err
+	}
+	if !lock {
+		return nil
+	}
+
+	a.logger.Warn("account locked", "user_id", user.ID, "until", until)
+	a.background(func() {
+		data := map[string]any{
+			"username":    user.Username,
+			"lockedUntil": until.Format(time.RFC1123),
+		}
+		err := a.mailer.Send(user.Email, "user_account_locked.tmpl", data)
+		if err != nil {
+			a.logger.Error(err.Error())
+		}
+	})
+	return nil
+}
+
+/*
+Failed logins for emails that have no account. They are counted and delayed
+exactly like the failures of a real account, otherwise the 429 a locked
+account answers with would tell which emails are registered
+*/
+type unknownLogins struct {
+	mu      sync.Mutex
+	entries map[string]*unknownLogin
+}
+
+type unknownLogin struct {
+	failures    int
+	lockedUntil time.Time
+	lastSeen    time.Time
+}
+
+// entries idle for longer than this are forgotten
+const unknownLoginIdle = 24 * time.Hour
+
+func newUnknownLogins() *unknownLogins {
+	u := &unknownLogins{entries: make(map[string]*unknownLogin)}
+
+	//a gorutine to remove stale entries from the map
+	go func() {
+		for {
+			time.Sleep(time.Minute)
+			u.mu.Lock()
+			for email, entry := range u.entries {
+				if time.Since(entry.lastSeen) > unknownLoginIdle {
+					delete(u.entries, email)
+				}
+			}
+			u.mu.Unlock()
+		}
+	}()
+	return u
+}
+
+// until when logins for the email are refused, the zero time when they are not
+func (u *unknownLogins) lockedUntil(email string) time.Time {
+	email = strings.ToLower(email)
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	entry, found := u.entries[email]
+	if !found {
+		return time.Time{}
+	}
+	return entry.lockedUntil
+}
+
+// count a failure for the email, the same way RecordFailedLogin and LockUntil count them for an account
+func (u *unknownLogins) recordFailure(email string, lockoutDelay func(failures int) (time.Duration, bool)) {
+	email = strings.ToLower(email)
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	entry, found := u.entries[email]
+	if !found {
+		entry = &unknownLogin{}
+		u.entries[email] = entry
+	}
+	entry.lastSeen = time.Now()
+	entry.failures++
+
+	delay, lock := lockoutDelay(entry.failures)
+	if delay > 0 {
+		entry.lockedUntil = time.Now().Add(delay)
+	}
+	if lock {
+		entry.failures = 0
+	}
+}
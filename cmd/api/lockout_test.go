@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestUnknownLoginsAreLockedLikeAccounts(t *testing.T) {
+	a := newTestApplication(t)
+	a.config.lockout.freeAttempts = 2
+	a.config.lockout.delay = time.Minute
+	a.config.lockout.threshold = 4
+	a.config.lockout.duration = time.Hour
+	logins := newUnknownLogins()
+
+	//the free attempts are never refused
+	for range 2 {
+		logins.recordFailure("nobody@example.com", a.lockoutDelay)
+	}
+	if until := logins.lockedUntil("nobody@example.com"); !until.IsZero() {
+		t.Fatalf("after the free attempts: locked until %v; want not locked", until)
+	}
+
+	//past them the same 429 and Retry-After an existing account gets, whatever the case of the email
+	logins.recordFailure("Nobody@Example.com", a.lockoutDelay)
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/tokens/authentication", nil)
+	if !a.refuseUntil(w, r, logins.lockedUntil("nobody@example.com")) {
+		t.Fatal("got the login through; want it refused")
+	}
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("got status %d; want %d", w.Code, http.StatusTooManyRequests)
+	}
+	if got := w.Header().Get("Retry-After"); got != "60" {
+		t.Errorf("got Retry-After %q; want %q", got, "60")
+	}
+
+	//reaching the threshold locks for the full duration
+	logins.recordFailure("nobody@example.com", a.lockoutDelay)
+	if wait := time.Until(logins.lockedUntil("nobody@example.com")); wait <= 59*time.Minute {
+		t.Errorf("after the threshold: waiting %v; want the full lockout", wait)
+	}
+}
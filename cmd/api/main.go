@@ -3,15 +3,19 @@ package main
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"flag"
 	"log/slog"
 	"os"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/abner-tech/Test3-Api.git/internal/data"
+	"github.com/abner-tech/Test3-Api.git/internal/jwt"
 	"github.com/abner-tech/Test3-Api.git/internal/mailer"
+	"github.com/abner-tech/Test3-Api.git/internal/oidc"
 	_ "github.com/lib/pq"
 )
 
@@ -24,9 +28,19 @@ type serverConfig struct {
 		dsn string
 	}
 	limiter struct {
-		rps     float64
-		burst   int
-		enabled bool
+		rps                float64
+		burst              int
+		enabled            bool
+		activationInterval time.Duration
+		resetInterval      time.Duration
+		authInterval       time.Duration
+		authBurst          int
+	}
+	lockout struct {
+		freeAttempts int
+		delay        time.Duration
+		threshold    int
+		duration     time.Duration
 	}
 	smtp struct {
 		host     string
@@ -38,6 +52,43 @@ type serverConfig struct {
 	cors struct {
 		trustedOrigins []string
 	}
+	tokenJanitor struct {
+		interval  time.Duration
+		batchSize int
+	}
+	account struct {
+		deletionGrace time.Duration
+		purgeInterval time.Duration
+	}
+	moderation struct {
+		reportThreshold int
+	}
+	password struct {
+		minLength      int
+		minClasses     int
+		rejectPersonal bool
+		breachedList   string
+		hash           string
+		bcryptCost     int
+		argon2Memory   uint
+		argon2Time     uint
+		argon2Threads  uint
+	}
+	oidc struct {
+		providers    []oidc.Config
+		redirectBase string
+	}
+	auth struct {
+		cacheTTL         time.Duration
+		accessTTL        time.Duration
+		refreshTTL       time.Duration
+		mode             string
+		signingKey       string
+		signingKeyID     string
+		verificationKeys string
+		hideAccounts     bool
+		totpIssuer       string
+	}
 }
 
 type applicationDependences struct {
@@ -50,7 +101,22 @@ type applicationDependences struct {
 	readingListModel data.ReadingListModel
 	bookModel        data.BookModel
 	reviewModel      data.ReviewModel
+	moderationModel  data.ModerationModel
 	permisionsModel  data.PermissionsModel
+	roleModel        data.RoleModel
+	twoFactorModel   data.TwoFactorModel
+	apiKeyModel      data.APIKeyModel
+	identityModel    data.IdentityModel
+	passwordPolicy   *data.PasswordPolicy
+	oidcProviders    map[string]*oidc.Provider
+	authCache        *authCache
+	tokenSigner      *jwt.Signer
+	tokenVerifier    *jwt.Verifier
+	tokenJanitor     tokenJanitorStats
+	activationMails  *keyedLimiter
+	resetMails       *keyedLimiter
+	authLimiter      *keyedLimiter //stricter per-IP limiter for the login and reset endpoints
+	unknownLogins    *unknownLogins
 }
 
 func main() {
@@ -65,6 +131,16 @@ func main() {
 	flag.Float64Var(&settings.limiter.rps, "limiter-rps", 2, "rate limiter maximum request per second")
 	flag.IntVar(&settings.limiter.burst, "limiter-burst", 5, "rate limiter maximum burst")
 	flag.BoolVar(&settings.limiter.enabled, "limiter-enabled", true, "enable rate limiter")
+	flag.DurationVar(&settings.limiter.activationInterval, "limiter-activation-interval", 5*time.Minute, "minimum time between activation emails to the same address")
+	flag.DurationVar(&settings.limiter.resetInterval, "limiter-reset-interval", 5*time.Minute, "minimum time between password reset emails to the same address")
+	flag.DurationVar(&settings.limiter.authInterval, "limiter-auth-interval", 10*time.Second, "time for one request to the login and reset endpoints to refill per IP")
+	flag.IntVar(&settings.limiter.authBurst, "limiter-auth-burst", 5, "login and reset requests allowed in a burst per IP")
+
+	//brute-force protection flags
+	flag.IntVar(&settings.lockout.freeAttempts, "lockout-free-attempts", 3, "failed logins allowed before the account has to wait between attempts")
+	flag.DurationVar(&settings.lockout.delay, "lockout-delay", time.Second, "wait after the first failed login past the free ones, doubled for every further failure")
+	flag.IntVar(&settings.lockout.threshold, "lockout-threshold", 10, "failed logins that lock the account (0 disables the lockout)")
+	flag.DurationVar(&settings.lockout.duration, "lockout-duration", 15*time.Minute, "how long a locked account has to wait")
 
 	//mailer flags
 	flag.StringVar(&settings.smtp.host, "smtp-host", "sandbox.smtp.mailtrap.io", "SMTP host")
@@ -82,6 +158,63 @@ func main() {
 			return nil
 		})
 
+	//expired token janitor flags
+	flag.DurationVar(&settings.tokenJanitor.interval, "token-janitor-interval", time.Hour, "how often to purge expired tokens (0 disables the janitor)")
+	//0 would never finish a purge and a negative LIMIT is an sql error
+	settings.tokenJanitor.batchSize = 1000
+	flag.Func("token-janitor-batch", "maximum number of expired tokens deleted per query (default 1000)",
+		func(val string) error {
+			batchSize, err := strconv.Atoi(val)
+			if err != nil {
+				return err
+			}
+			if batchSize < 1 {
+				return errors.New("must be at least 1")
+			}
+			settings.tokenJanitor.batchSize = batchSize
+			return nil
+		})
+
+	//password policy flags, they apply to new passwords only
+	flag.IntVar(&settings.password.minLength, "password-min-length", 8, "minimum length of new passwords in bytes")
+	flag.IntVar(&settings.password.minClasses, "password-min-classes", 1, "character classes (lower, upper, digit, symbol) new passwords must use")
+	flag.BoolVar(&settings.password.rejectPersonal, "password-reject-personal", true, "refuse new passwords containing the username or email")
+	flag.StringVar(&settings.password.breachedList, "password-breached-list", "", "file of SHA-1 hashes of breached passwords to refuse, on top of the bundled list")
+	flag.StringVar(&settings.password.hash, "password-hash", data.HashArgon2id, "algorithm for new password hashes (argon2id|bcrypt)")
+	flag.IntVar(&settings.password.bcryptCost, "password-bcrypt-cost", 12, "bcrypt cost for new password hashes")
+	flag.UintVar(&settings.password.argon2Memory, "password-argon2-memory", 64*1024, "argon2id memory in KiB for new password hashes")
+	flag.UintVar(&settings.password.argon2Time, "password-argon2-time", 3, "argon2id passes for new password hashes")
+	flag.UintVar(&settings.password.argon2Threads, "password-argon2-threads", 2, "argon2id parallelism for new password hashes")
+
+	//account flags
+	flag.IntVar(&settings.moderation.reportThreshold, "moderation-report-threshold", 3, "open reports that hold a review for moderation (0 = never)")
+	flag.DurationVar(&settings.account.deletionGrace, "account-deletion-grace", 14*24*time.Hour, "how long a deleted account can still be recovered by logging in")
+	flag.DurationVar(&settings.account.purgeInterval, "account-purge-interval", time.Hour, "how often to delete accounts whose deletion grace period is over (0 disables it)")
+
+	//external login providers, the flag can be given once per provider
+	flag.Func("oidc-provider", "OpenID Connect provider: name=NAME,issuer=URL,client-id=ID,client-secret=SECRET[,scopes=openid email profile]",
+		func(val string) error {
+			config, err := oidc.ParseConfig(val)
+			if err != nil {
+				return err
+			}
+			settings.oidc.providers = append(settings.oidc.providers, config)
+			return nil
+		})
+	flag.StringVar(&settings.oidc.redirectBase, "oidc-redirect-base", "http://localhost:4000", "public base url of the API, providers send users back to <base>/api/v1/oidc/<name>/callback")
+
+	//authentication flags
+	flag.DurationVar(&settings.auth.cacheTTL, "auth-cache-ttl", 0, "how long to cache authenticated users and their permissions (0 disables the cache)")
+	flag.DurationVar(&settings.auth.accessTTL, "auth-access-ttl", 15*time.Minute, "lifetime of authentication (access) tokens")
+	flag.DurationVar(&settings.auth.refreshTTL, "auth-refresh-ttl", 7*24*time.Hour, "lifetime of refresh tokens")
+	flag.StringVar(&settings.auth.mode, "auth-mode", "opaque", "authentication token type (opaque|signed). Signed tokens are not checked against the database: "+
+		"they keep working after logout, password change, permission changes or account deletion until they expire, so -auth-access-ttl is capped at "+maxSignedAccessTTL.String()+" in signed mode")
+	flag.StringVar(&settings.auth.signingKey, "auth-signing-key", "", "PEM file with the ed25519 private key used to sign tokens (signed mode)")
+	flag.StringVar(&settings.auth.signingKeyID, "auth-signing-key-id", "", "key id written into signed tokens (signed mode)")
+	flag.StringVar(&settings.auth.verificationKeys, "auth-verification-keys", "", "directory of PEM ed25519 public keys, named <key id>.pem, accepted for signed tokens")
+	flag.BoolVar(&settings.auth.hideAccounts, "auth-hide-accounts", false, "answer registrations, password resets and activation requests the same way whether or not the email address has an account")
+	flag.StringVar(&settings.auth.totpIssuer, "auth-totp-issuer", "Books and More Community", "name shown for the account in authenticator apps")
+
 	flag.Parse()
 
 	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
@@ -98,16 +231,114 @@ func main() {
 
 	logger.Info("Database Connection Pool Established")
 
+	//signed tokens need their keys before we can serve anything
+	var tokenSigner *jwt.Signer
+	var tokenVerifier *jwt.Verifier
+	switch settings.auth.mode {
+	case "opaque":
+	case "signed":
+		//a signed token cannot be revoked, its lifetime is all that limits a stolen or stale one
+		if settings.auth.accessTTL > maxSignedAccessTTL {
+			logger.Error("-auth-access-ttl is too long for signed auth mode", "ttl", settings.auth.accessTTL, "max", maxSignedAccessTTL)
+			os.Exit(1)
+		}
+		tokenSigner, tokenVerifier, err = loadTokenKeys(settings)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+	default:
+		logger.Error("invalid auth mode", "mode", settings.auth.mode)
+		os.Exit(1)
+	}
+
+	//stored hashes made with other settings are upgraded when their owner logs in
+	hashing := data.DefaultPasswordHashing()
+	hashing.Algorithm = settings.password.hash
+	hashing.BcryptCost = settings.password.bcryptCost
+	hashing.Argon2Memory = uint32(settings.password.argon2Memory)
+	hashing.Argon2Time = uint32(settings.password.argon2Time)
+	hashing.Argon2Threads = uint8(settings.password.argon2Threads)
+	err = hashing.Validate()
+	if err == nil && settings.password.argon2Threads > 255 {
+		err = errors.New("argon2id threads must be at most 255")
+	}
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
+	breached := data.BundledBreachedPasswords()
+	if settings.password.breachedList != "" {
+		err = breached.LoadFile(settings.password.breachedList)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+	}
+	passwordPolicy := &data.PasswordPolicy{
+		MinLength:      settings.password.minLength,
+		MinClasses:     settings.password.minClasses,
+		RejectPersonal: settings.password.rejectPersonal,
+		Breached:       breached,
+	}
+
 	appInstance := &applicationDependences{
 		config:           settings,
 		logger:           logger,
-		userModel:        data.UserModel{DB: db},
+		userModel:        data.UserModel{DB: db, Hashing: hashing},
 		mailer:           mailer.New(settings.smtp.host, settings.smtp.port, settings.smtp.username, settings.smtp.password, settings.smtp.sender),
 		tokenModel:       data.TokenModel{DB: db},
 		readingListModel: data.ReadingListModel{DB: db},
 		bookModel:        data.BookModel{DB: db},
 		reviewModel:      data.ReviewModel{DB: db},
+		moderationModel:  data.ModerationModel{DB: db},
 		permisionsModel:  data.PermissionsModel{DB: db},
+		roleModel:        data.RoleModel{DB: db},
+		twoFactorModel:   data.TwoFactorModel{DB: db},
+		apiKeyModel:      data.APIKeyModel{DB: db},
+		identityModel:    data.IdentityModel{DB: db},
+		oidcProviders:    newOIDCProviders(settings),
+		passwordPolicy:   passwordPolicy,
+		authCache:        newAuthCache(settings.auth.cacheTTL),
+		tokenSigner:      tokenSigner,
+		tokenVerifier:    tokenVerifier,
+		activationMails:  newKeyedLimiter(settings.limiter.activationInterval, 1),
+		resetMails:       newKeyedLimiter(settings.limiter.resetInterval, 1),
+		authLimiter:      newKeyedLimiter(settings.limiter.authInterval, settings.limiter.authBurst),
+		unknownLogins:    newUnknownLogins(),
+	}
+
+	//run a one-off command instead of the server, e.g. go run ./cmd/api purge-tokens, purge-accounts or repair-ratings
+	switch flag.Arg(0) {
+	case "":
+	case "purge-tokens":
+		removed, err := appInstance.purgeExpiredTokens(context.Background())
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+		logger.Info("expired tokens purged", "removed", removed)
+		return
+	case "purge-accounts":
+		removed, err := appInstance.purgeDeletedAccounts(context.Background())
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+		logger.Info("deleted accounts purged", "removed", removed)
+		return
+	case "repair-ratings":
+		repaired, err := appInstance.bookModel.RepairRatings(context.Background())
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+		logger.Info("book ratings recomputed", "repaired", repaired)
+		return
+	default:
+		logger.Error("unknown command", "command", flag.Arg(0))
+		os.Exit(1)
 	}
 
 	err = appInstance.serve()
@@ -139,3 +370,36 @@ func openDB(settings serverConfig) (*sql.DB, error) {
 	//return the connection pool (sql.DB)
 	return db, nil
 }
+
+// the longest a signed authentication token may live, it stays valid after logout until then
+const maxSignedAccessTTL = 15 * time.Minute
+
+func loadTokenKeys(settings serverConfig) (*jwt.Signer, *jwt.Verifier, error) {
+	if settings.auth.signingKey == "" || settings.auth.signingKeyID == "" {
+		return nil, nil, errors.New("signed auth mode requires -auth-signing-key and -auth-signing-key-id")
+	}
+
+	signer, err := jwt.LoadSigner(settings.auth.signingKey, settings.auth.signingKeyID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	//older keys stay in the directory until the tokens signed with them have expired
+	verifier, err := jwt.LoadVerifier(settings.auth.verificationKeys)
+	if err != nil {
+		return nil, nil, err
+	}
+	verifier.Trust(signer)
+
+	return signer, verifier, nil
+}
+
+// one provider per -oidc-provider flag, each with its own callback url
+func newOIDCProviders(settings serverConfig) map[string]*oidc.Provider {
+	providers := make(map[string]*oidc.Provider)
+	for _, config := range settings.oidc.providers {
+		config.RedirectURL = strings.TrimSuffix(settings.oidc.redirectBase, "/") + "/api/v1/oidc/" + config.Name + "/callback"
+		providers[config.Name] = oidc.NewProvider(config)
+	}
+	return providers
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/abner-tech/Test3-Api.git/internal/data"
+)
+
+func TestRequireSession(t *testing.T) {
+	a := newTestApplication(t)
+	user := &data.User{ID: 1, Activated: true}
+	next := func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	tests := []struct {
+		name string
+		key  *data.APIKey
+		want int
+	}{
+		{"logged in", nil, http.StatusOK},
+		{"api key", &data.APIKey{Permissions: data.Permissions{"users:write"}}, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPatch, "/api/v1/users/me", nil)
+			r = a.contextSetUser(r, user, nil)
+			if tt.key != nil {
+				r = a.contextSetAPIKey(r, tt.key)
+			}
+			w := httptest.NewRecorder()
+
+			a.requireSession(next)(w, r)
+			if w.Code != tt.want {
+				t.Errorf("got status %d; want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/abner-tech/Test3-Api.git/internal/data"
+	"github.com/abner-tech/Test3-Api.git/internal/validator"
+)
+
+// report a review to the moderators
+func (a *applicationDependences) reportReviewHandler(w http.ResponseWriter, r *http.Request) {
+	review_id, err := a.readIDParam(r, "r_id")
+	if err != nil || review_id < 1 {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	var incomingData struct {
+		Reason  string `json:"reason"`
+		Comment string `json:"comment"`
+	}
+
+	err = a.readJSON(w, r, &incomingData)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	report := &data.ReviewReport{
+		ReviewID: review_id,
+		UserID:   a.contextGetUser(r).ID,
+		Reason:   incomingData.Reason,
+		Comment:  incomingData.Comment,
+	}
+
+	v := validator.New()
+	data.ValidateReport(v, report)
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	review, err := a.reviewModel.GetByID(review_id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !a.canSeeReview(r, review) {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	//whether the report held the review is the moderators' business, not the reporter's
+	_, err = a.moderationModel.AddReport(review, report, a.config.moderation.reportThreshold)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateReport):
+			a.duplicateReportResponse(w, r)
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	data := envelope{
+		"report": report,
+	}
+
+	err = a.writeJSON(w, http.StatusCreated, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// reviews waiting for a moderator, the most reported first
+func (a *applicationDependences) listModerationQueueHandler(w http.ResponseWriter, r *http.Request) {
+	var queryParameterData struct {
+		Status string
+		data.Fileters
+	}
+
+	queryParameter := r.URL.Query()
+
+	v := validator.New()
+
+	queryParameterData.Status = a.getSingleQueryParameter(queryParameter, "status", "")
+	queryParameterData.Fileters.Page = a.getSingleIntigerParameter(queryParameter, "page", 1, v)
+	queryParameterData.Fileters.PageSize = a.getSingleIntigerParameter(queryParameter, "page_size", 10, v)
+	//the queue has its own order
+	queryParameterData.Fileters.Sorting = "id"
+	queryParameterData.Fileters.SortSafeList = []string{"id"}
+
+	data.ValidateFilters(v, queryParameterData.Fileters)
+	v.Check(validator.PermittedValue(queryParameterData.Status, "", "reported", data.ReviewPending, data.ReviewHidden, data.ReviewVisible),
+		"status", "must be one of: reported, pending, hidden, visible")
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	entries, metadata, err := a.moderationModel.Queue(queryParameterData.Status, queryParameterData.Fileters)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	data := envelope{
+		"queue":     entries,
+		"@metadata": metadata,
+	}
+
+	err = a.writeJSON(w, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// a review with its reports and moderation history
+func (a *applicationDependences) showModeratedReviewHandler(w http.ResponseWriter, r *http.Request) {
+	review_id, err := a.readIDParam(r, "r_id")
+	if err != nil || review_id < 1 {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	review, err := a.reviewModel.GetByID(review_id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	reports, err := a.moderationModel.GetReports(review_id)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	actions, err := a.moderationModel.GetActions(review_id)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	data := envelope{
+		"review":  review,
+		"reports": reports,
+		"actions": actions,
+	}
+
+	err = a.writeJSON(w, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// hide, approve or hold a review, the author is emailed when their review gets hidden
+func (a *applicationDependences) moderateReviewHandler(w http.ResponseWriter, r *http.Request) {
+	review_id, err := a.readIDParam(r, "r_id")
+	if err != nil || review_id < 1 {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	var incomingData struct {
+		Action string `json:"action"`
+		Note   string `json:"note"`
+	}
+
+	err = a.readJSON(w, r, &incomingData)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	moderator := a.contextGetUser(r)
+	action := &data.ModerationAction{
+		ModeratorID: &moderator.ID,
+		Action:      incomingData.Action,
+		Note:        incomingData.Note,
+	}
+
+	v := validator.New()
+	data.ValidateModerationAction(v, action)
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	review, err := a.reviewModel.GetByID(review_id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	previousStatus := review.Status
+
+	err = a.moderationModel.Apply(review, action)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if review.Status == data.ReviewHidden && previousStatus != data.ReviewHidden {
+		a.sendReviewHiddenEmail(review, action.Note)
+	}
+
+	data := envelope{
+		"review": review,
+		"action": action,
+	}
+
+	err = a.writeJSON(w, http.StatusCreated, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// tell the author their review was hidden and why, in the background
+func (a *applicationDependences) sendReviewHiddenEmail(review *data.Review, note string) {
+	a.background(func() {
+		author, err := a.userModel.GetByID(review.User_ID)
+		if err != nil {
+			a.logger.Error(err.Error())
+			return
+		}
+
+		book, err := a.bookModel.GetByID(review.Book_ID)
+		if err != nil {
+			a.logger.Error(err.Error())
+			return
+		}
+
+		data := map[string]any{
+			"username":   author.Username,
+			"bookTitle":  book.Title,
+			"reviewText": review.ReviewText,
+			"note":       note,
+		}
+		err = a.mailer.Send(author.Email, "review_hidden.tmpl", data)
+		if err != nil {
+			a.logger.Error(err.Error())
+		}
+	})
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/abner-tech/Test3-Api.git/internal/data"
+	"github.com/abner-tech/Test3-Api.git/internal/oidc"
+	"github.com/abner-tech/Test3-Api.git/internal/validator"
+	"github.com/julienschmidt/httprouter"
+)
+
+// how long the user has to log in at the provider and come back
+const oidcLoginTTL = 10 * time.Minute
+
+// the provider named in the 'provider' url parameter
+func (a *applicationDependences) oidcProvider(r *http.Request) (*oidc.Provider, bool) {
+	name := httprouter.ParamsFromContext(r.Context()).ByName("provider")
+	provider, found := a.oidcProviders[name]
+	return provider, found
+}
+
+// list the providers users can log in with
+func (a *applicationDependences) listOIDCProvidersHandler(w http.ResponseWriter, r *http.Request) {
+	names := []string{}
+	for name := range a.oidcProviders {
+		names = append(names, name)
+	}
+
+	err := a.writeJSON(w, http.StatusOK, envelope{"providers": names}, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// start a login with a provider, the client sends the user to the returned url
+func (a *applicationDependences) oidcLoginHandler(w http.ResponseWriter, r *http.Request) {
+	provider, found := a.oidcProvider(r)
+	if !found {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	//state ties the callback to this login, the nonce ties the ID token to it
+	var values [3]string
+	for i := range values {
+		value, err := oidc.RandomString()
+		if err != nil {
+			a.serverErrorResponse(w, r, err)
+			return
+		}
+		values[i] = value
+	}
+	state, nonce, verifier := values[0], values[1], values[2]
+
+	authorizationURL, err := provider.AuthCodeURL(r.Context(), state, nonce, verifier)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	login := &data.PendingLogin{
+		Provider: provider.Name,
+		Verifier: verifier,
+		Nonce:    nonce,
+	}
+	err = a.identityModel.InsertPendingLogin(state, login, oidcLoginTTL)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	data := envelope{
+		"authorization_url": authorizationURL,
+	}
+
+	err = a.writeJSON(w, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+/*
+the provider sends the user back here. We check the login, find or create the
+user linked to the external account and log them in like a password login
+*/
+func (a *applicationDependences) oidcCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	provider, found := a.oidcProvider(r)
+	if !found {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	query := r.URL.Query()
+	v := validator.New()
+	if providerError := query.Get("error"); providerError != "" {
+		v.AddError("provider", providerError)
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	code := query.Get("code")
+	state := query.Get("state")
+	v.Check(code != "", "code", "must be provided")
+	v.Check(state != "", "state", "must be provided")
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	login, err := a.identityModel.TakePendingLogin(provider.Name, state)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("state", "invalid or expired login, please start again")
+			a.failedValidationResponse(w, r, v.Errors)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	claims, err := provider.Exchange(r.Context(), code, login.Verifier, login.Nonce)
+	if err != nil {
+		switch {
+		case errors.Is(err, oidc.ErrInvalidIDToken):
+			a.invalidCredentialResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	user, err := a.userForIdentity(provider.Name, claims, v)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	a.loginUser(w, r, user)
+}
+
+/*
+find the user for an external account, in order: an account already linked to
+it, an account with the same email when the provider says it verified the
+email, or a new account. Problems the user can fix are added to v
+*/
+func (a *applicationDependences) userForIdentity(provider string, claims *oidc.Claims, v *validator.Validator) (*data.User, error) {
+	user, err := a.findUserForIdentity(provider, claims, v)
+	if errors.Is(err, data.ErrDuplicateEmail) {
+		//the email got an account after we looked, e.g. the same login finishing twice, look again
+		user, err = a.findUserForIdentity(provider, claims, v)
+	}
+	if errors.Is(err, data.ErrDuplicateEmail) {
+		v.AddError("email", "an account with this email address already exists, log in with your password")
+		return nil, nil
+	}
+	return user, err
+}
+
+func (a *applicationDependences) findUserForIdentity(provider string, claims *oidc.Claims, v *validator.Validator) (*data.User, error) {
+	userID, err := a.identityModel.GetUserID(provider, claims.Subject)
+	if err == nil {
+		return a.userModel.GetByID(userID)
+	}
+	if !errors.Is(err, data.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	if claims.Email == "" {
+		v.AddError("email", "the provider did not share an email address")
+		return nil, nil
+	}
+
+	user, err := a.userModel.GetByEmail(claims.Email)
+	switch {
+	case err == nil:
+		//without a verified email anyone could claim the account
+		if !claims.EmailVerified {
+			v.AddError("email", "an account with this email address already exists, log in with your password")
+			return nil, nil
+		}
+
+		if !user.Activated {
+			user.Activated = true
+			err = a.userModel.Update(user)
+			if err != nil {
+				return nil, err
+			}
+			err = a.tokenModel.DeleteAllForUser(data.ScopeActivation, user.ID)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+	case errors.Is(err, data.ErrRecordNotFound):
+		user, err = a.createUserForIdentity(claims, v)
+		if err != nil || user == nil {
+			return nil, err
+		}
+
+	default:
+		return nil, err
+	}
+
+	err = a.identityModel.Link(user.ID, provider, claims.Subject, claims.Email)
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// a new account for someone who signed up through a provider, ErrDuplicateEmail when the email was taken meanwhile
+func (a *applicationDependences) createUserForIdentity(claims *oidc.Claims, v *validator.Validator) (*data.User, error) {
+	username := claims.Name
+	if username == "" {
+		username, _, _ = strings.Cut(claims.Email, "@")
+	}
+
+	user := &data.User{
+		Username:  username,
+		Email:     claims.Email,
+		Activated: claims.EmailVerified,
+	}
+
+	//nobody knows this password, the user can set one with a password reset
+	password, err := oidc.RandomString()
+	if err != nil {
+		return nil, err
+	}
+	err = a.userModel.SetPassword(user, password)
+	if err != nil {
+		return nil, err
+	}
+
+	data.ValidateUser(v, user)
+	if !v.IsEmpty() {
+		return nil, nil
+	}
+
+	err = a.userModel.Insert(user)
+	if err != nil {
+		return nil, err
+	}
+
+	err = a.roleModel.AssignToUser(user.ID, data.RoleReader)
+	if err != nil {
+		return nil, err
+	}
+
+	//the provider did not vouch for the email so we check it ourselves
+	if !user.Activated {
+		token, err := a.tokenModel.New(user.ID, 3*24*time.Hour, data.ScopeActivation)
+		if err != nil {
+			return nil, err
+		}
+
+		a.background(func() {
+			data := map[string]any{
+				"activationToken": token.PlainText,
+				"userID":          user.ID,
+			}
+			err := a.mailer.Send(user.Email, "user_welcome.tmpl", data)
+			if err != nil {
+				a.logger.Error(err.Error())
+			}
+		})
+	}
+	return user, nil
+}
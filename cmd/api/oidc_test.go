@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/abner-tech/Test3-Api.git/internal/data"
+	"github.com/abner-tech/Test3-Api.git/internal/oidc"
+	"github.com/abner-tech/Test3-Api.git/internal/oidc/oidctest"
+	"github.com/abner-tech/Test3-Api.git/internal/validator"
+	"github.com/julienschmidt/httprouter"
+)
+
+// an application that can log in with a fake provider named "fake"
+func newOIDCTestApplication(t *testing.T) (*applicationDependences, *oidctest.Server) {
+	t.Helper()
+
+	server, err := oidctest.NewServer("client", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(server.Close)
+
+	var settings serverConfig
+	settings.oidc.redirectBase = "http://localhost:4000"
+	settings.oidc.providers = []oidc.Config{{
+		Name:         "fake",
+		Issuer:       server.Issuer(),
+		ClientID:     "client",
+		ClientSecret: "secret",
+	}}
+	return newTestDBApplication(t, settings), server
+}
+
+// call a handler of the fake provider the way the router would
+func serveOIDC(handler http.HandlerFunc, target string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, target, nil)
+	params := httprouter.Params{{Key: "provider", Value: "fake"}}
+	r = r.WithContext(context.WithValue(r.Context(), httprouter.ParamsKey, params))
+
+	w := httptest.NewRecorder()
+	handler(w, r)
+	return w
+}
+
+// log in through the provider from start to finish and return the callback response
+func oidcLogin(t *testing.T, a *applicationDependences, server *oidctest.Server, login oidctest.Login) *httptest.ResponseRecorder {
+	t.Helper()
+
+	w := serveOIDC(a.oidcLoginHandler, "/api/v1/oidc/fake/login")
+	if w.Code != http.StatusOK {
+		t.Fatalf("login: got status %d: %s", w.Code, w.Body)
+	}
+	var started struct {
+		AuthorizationURL string `json:"authorization_url"`
+	}
+	err := json.NewDecoder(w.Body).Decode(&started)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	callback, err := server.Authorize(started.AuthorizationURL, login)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return serveOIDC(a.oidcCallbackHandler, callback.RequestURI())
+}
+
+// an address nobody has used yet, its account is deleted when the test ends
+func uniqueEmail(t *testing.T, a *applicationDependences) string {
+	t.Helper()
+
+	email := fmt.Sprintf("oidc%d@example.com", time.Now().UnixNano())
+	t.Cleanup(func() {
+		_, err := a.userModel.DB.Exec(`DELETE FROM users WHERE email = $1`, email)
+		if err != nil {
+			t.Error(err)
+		}
+	})
+	return email
+}
+
+func TestOIDCLoginRoundTrip(t *testing.T) {
+	a, server := newOIDCTestApplication(t)
+	email := uniqueEmail(t, a)
+	subject := email
+
+	w := oidcLogin(t, a, server, oidctest.Login{Subject: subject, Email: email, EmailVerified: true, Name: "oidc user"})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("got status %d: %s", w.Code, w.Body)
+	}
+
+	user, err := a.userModel.GetByEmail(email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !user.Activated {
+		t.Error("a verified email did not activate the new account")
+	}
+
+	//the second login finds the account through the link
+	w = oidcLogin(t, a, server, oidctest.Login{Subject: subject, Email: email, EmailVerified: true})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("second login: got status %d: %s", w.Code, w.Body)
+	}
+	linked, err := a.identityModel.GetUserID("fake", subject)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if linked != user.ID {
+		t.Errorf("identity linked to user %d; want %d", linked, user.ID)
+	}
+}
+
+func TestOIDCCallbackState(t *testing.T) {
+	a, server := newOIDCTestApplication(t)
+	email := uniqueEmail(t, a)
+
+	w := serveOIDC(a.oidcLoginHandler, "/api/v1/oidc/fake/login")
+	var started struct {
+		AuthorizationURL string `json:"authorization_url"`
+	}
+	err := json.NewDecoder(w.Body).Decode(&started)
+	if err != nil {
+		t.Fatal(err)
+	}
+	callback, err := server.Authorize(started.AuthorizationURL, oidctest.Login{Subject: email, Email: email, EmailVerified: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := callback.Query()
+
+	//a state we never handed out
+	w = serveOIDC(a.oidcCallbackHandler, "/api/v1/oidc/fake/callback?code="+query.Get("code")+"&state=forged")
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("forged state: got status %d; want %d", w.Code, http.StatusUnprocessableEntity)
+	}
+
+	w = serveOIDC(a.oidcCallbackHandler, callback.RequestURI())
+	if w.Code != http.StatusCreated {
+		t.Fatalf("got status %d: %s", w.Code, w.Body)
+	}
+
+	//each state can only be used once
+	w = serveOIDC(a.oidcCallbackHandler, callback.RequestURI())
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("replayed state: got status %d; want %d", w.Code, http.StatusUnprocessableEntity)
+	}
+}
+
+func TestOIDCCallbackRefusesInvalidIDToken(t *testing.T) {
+	tests := []struct {
+		name  string
+		login oidctest.Login
+	}{
+		{"wrong audience", oidctest.Login{Audience: "someone-else"}},
+		{"wrong issuer", oidctest.Login{Issuer: "https://evil.example.com"}},
+		{"wrong nonce", oidctest.Login{Nonce: "replayed"}},
+		{"expired", oidctest.Login{Expiry: time.Now().Add(-time.Minute)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, server := newOIDCTestApplication(t)
+			email := uniqueEmail(t, a)
+
+			tt.login.Subject = email
+			tt.login.Email = email
+			tt.login.EmailVerified = true
+			w := oidcLogin(t, a, server, tt.login)
+			if w.Code != http.StatusUnauthorized {
+				t.Errorf("got status %d; want %d", w.Code, http.StatusUnauthorized)
+			}
+
+			_, err := a.userModel.GetByEmail(email)
+			if !errors.Is(err, data.ErrRecordNotFound) {
+				t.Errorf("an account was created: %v", err)
+			}
+		})
+	}
+}
+
+// an existing account is only linked when the provider verified the email
+func TestOIDCLinkExistingAccount(t *testing.T) {
+	tests := []struct {
+		name     string
+		verified bool
+		want     int
+	}{
+		{"verified email", true, http.StatusCreated},
+		{"unverified email", false, http.StatusUnprocessableEntity},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, server := newOIDCTestApplication(t)
+			email := uniqueEmail(t, a)
+
+			existing := &data.User{Username: "existing", Email: email, Activated: true}
+			err := a.userModel.SetPassword(existing, "correct horse battery staple")
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = a.userModel.Insert(existing)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			w := oidcLogin(t, a, server, oidctest.Login{Subject: email, Email: email, EmailVerified: tt.verified})
+			if w.Code != tt.want {
+				t.Fatalf("got status %d; want %d: %s", w.Code, tt.want, w.Body)
+			}
+
+			linked, err := a.identityModel.GetUserID("fake", email)
+			switch {
+			case tt.verified && err != nil:
+				t.Errorf("identity not linked: %v", err)
+			case tt.verified && linked != existing.ID:
+				t.Errorf("identity linked to user %d; want %d", linked, existing.ID)
+			case !tt.verified && !errors.Is(err, data.ErrRecordNotFound):
+				t.Errorf("identity linked without a verified email: %v", err)
+			}
+		})
+	}
+}
+
+// someone registering the address between our lookup and the insert must not become a 500
+func TestCreateUserForIdentityTakenEmail(t *testing.T) {
+	a, _ := newOIDCTestApplication(t)
+	email := uniqueEmail(t, a)
+
+	existing := &data.User{Username: "existing", Email: email}
+	err := a.userModel.SetPassword(existing, "correct horse battery staple")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = a.userModel.Insert(existing)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims := &oidc.Claims{Subject: email, Email: email, EmailVerified: false}
+	_, err = a.createUserForIdentity(claims, validator.New())
+	if !errors.Is(err, data.ErrDuplicateEmail) {
+		t.Fatalf("got %v; want ErrDuplicateEmail", err)
+	}
+
+	//going through the lookup again the unverified claim is refused as a validation error
+	v := validator.New()
+	user, err := a.userForIdentity("fake", claims, v)
+	if err != nil || user != nil || v.IsEmpty() {
+		t.Errorf("got user %v, error %v and validation errors %v", user, err, v.Errors)
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/abner-tech/Test3-Api.git/internal/data"
+	"github.com/abner-tech/Test3-Api.git/internal/validator"
+)
+
+// list every permission code that can be granted
+func (a *applicationDependences) listPermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	permissions, err := a.permisionsModel.ListAll()
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	data := envelope{
+		"permissions": permissions,
+	}
+
+	err = a.writeJSON(w, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// show the permissions granted directly to a user and the effective set including roles
+func (a *applicationDependences) listUserPermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := a.readIDParam(r, "u_id")
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	err = a.userModel.UserExist(userID)
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	granted, err := a.permisionsModel.GetGrantedForUser(userID)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	effective, err := a.permisionsModel.GetAllForUser(userID)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	data := envelope{
+		"granted":   granted,
+		"effective": effective,
+	}
+
+	err = a.writeJSON(w, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// grant (POST) or revoke (DELETE) permission codes for a user and record the change
+func (a *applicationDependences) updateUserPermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := a.readIDParam(r, "u_id")
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	var incomingData struct {
+		Permissions []string `json:"permissions"`
+	}
+
+	err = a.readJSON(w, r, &incomingData)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	//only known permission codes may be granted or revoked
+	known, err := a.permisionsModel.ListAll()
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(len(incomingData.Permissions) > 0, "permissions", "must contain at least one permission code")
+	for i, code := range incomingData.Permissions {
+		v.Check(known.Include(code), fmt.Sprintf("permissions[%d]", i), "unknown permission code")
+	}
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = a.userModel.UserExist(userID)
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	//the change and its audit entry are written together, entry is nil when nothing changed
+	actorID := a.contextGetUser(r).ID
+	var entry *data.PermissionAudit
+	if r.Method == http.MethodDelete {
+		entry, err = a.permisionsModel.RevokeForUser(actorID, userID, incomingData.Permissions...)
+	} else {
+		entry, err = a.permisionsModel.GrantForUser(actorID, userID, incomingData.Permissions...)
+	}
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+	a.authCache.DeleteUser(userID)
+
+	granted, err := a.permisionsModel.GetGrantedForUser(userID)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	data := envelope{
+		"granted": granted,
+		"audit":   entry,
+	}
+
+	err = a.writeJSON(w, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// the history of permission changes for a user
+func (a *applicationDependences) listUserPermissionsAuditHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := a.readIDParam(r, "u_id")
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	err = a.userModel.UserExist(userID)
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	entries, err := a.permisionsModel.GetAuditForUser(userID)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	data := envelope{
+		"audit": entries,
+	}
+
+	err = a.writeJSON(w, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/abner-tech/Test3-Api.git/internal/data"
+)
+
+// resource ownership policy: a user may change a resource they own, or any
+// resource if they hold the elevated admin permission for that resource type
+func (a *applicationDependences) isOwnerOrAdmin(r *http.Request, ownerID int64, adminCode string) bool {
+	//a resource without an owner (0) is only open to admins
+	user := a.contextGetUser(r)
+	if ownerID != 0 && !user.IsAnonymous() && user.ID == ownerID {
+		return true
+	}
+
+	//not the owner, so check for the elevated permission
+	return a.effectivePermissions(r).Include(adminCode)
+}
+
+// what the request may do: the permissions of the user, limited to the scope of the api key if one was used
+func (a *applicationDependences) effectivePermissions(r *http.Request) data.Permissions {
+	permissions := a.contextGetPermissions(r)
+	if key := a.contextGetAPIKey(r); key != nil {
+		return permissions.Intersect(key.Permissions)
+	}
+	return permissions
+}
+
+// review visibility: hidden and pending reviews are only shown to their author and to moderators
+func (a *applicationDependences) reviewViewer(r *http.Request) data.ReviewViewer {
+	return data.ReviewViewer{
+		UserID:    a.contextGetUser(r).ID,
+		Moderator: a.effectivePermissions(r).Include("reviews:moderate"),
+	}
+}
+
+func (a *applicationDependences) canSeeReview(r *http.Request, review *data.Review) bool {
+	viewer := a.reviewViewer(r)
+	return review.Status == data.ReviewVisible || viewer.Moderator || review.User_ID == viewer.UserID
+}
+
+// owner of the reading list in the 'rl_id' url parameter
+func (a *applicationDependences) readingListOwner(r *http.Request) (int64, error) {
+	id, err := a.readIDParam(r, "rl_id")
+	if err != nil {
+		return 0, data.ErrRecordNotFound
+	}
+
+	list, err := a.readingListModel.GetByID(id)
+	if err != nil {
+		return 0, err
+	}
+	return list.CreatedBy, nil
+}
+
+// owner of the review in the 'r_id' url parameter
+func (a *applicationDependences) reviewOwner(r *http.Request) (int64, error) {
+	id, err := a.readIDParam(r, "r_id")
+	if err != nil {
+		return 0, data.ErrRecordNotFound
+	}
+
+	review, err := a.reviewModel.GetByID(id)
+	if err != nil {
+		return 0, err
+	}
+	return review.User_ID, nil
+}
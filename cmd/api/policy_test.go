@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/abner-tech/Test3-Api.git/internal/data"
+)
+
+func TestRequireOwnership(t *testing.T) {
+	owner := &data.User{ID: 1, Activated: true}
+	other := &data.User{ID: 2, Activated: true}
+	inactiveOwner := &data.User{ID: 1}
+	admin := data.Permissions{"reading_list:admin"}
+
+	ownedBy := func(id int64, err error) ownerLookup {
+		return func(r *http.Request) (int64, error) {
+			return id, err
+		}
+	}
+
+	tests := []struct {
+		name        string
+		lookup      ownerLookup
+		user        *data.User
+		permissions data.Permissions
+		want        int
+	}{
+		{"owner", ownedBy(1, nil), owner, nil, http.StatusOK},
+		{"admin", ownedBy(1, nil), other, admin, http.StatusOK},
+		{"non-owner", ownedBy(1, nil), other, data.Permissions{"reading_list:read"}, http.StatusForbidden},
+		{"anonymous", ownedBy(1, nil), data.AnonymouseUser, nil, http.StatusUnauthorized},
+		{"inactive owner", ownedBy(1, nil), inactiveOwner, nil, http.StatusForbidden},
+		{"no owner", ownedBy(0, nil), other, nil, http.StatusForbidden},
+		{"no owner, admin", ownedBy(0, nil), other, admin, http.StatusOK},
+		{"missing resource", ownedBy(0, data.ErrRecordNotFound), owner, nil, http.StatusNotFound},
+		{"lookup failure", ownedBy(0, errors.New("boom")), owner, nil, http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newTestApplication(t)
+			next := func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}
+
+			w := serveAs(a, a.requireOwnership("reading_list:admin", tt.lookup, next), tt.user, tt.permissions)
+			if w.Code != tt.want {
+				t.Errorf("got status %d; want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsOwnerOrAdminWithAPIKey(t *testing.T) {
+	a := newTestApplication(t)
+	user := &data.User{ID: 2, Activated: true}
+
+	tests := []struct {
+		name    string
+		ownerID int64
+		scope   data.Permissions
+		want    bool
+	}{
+		{"key holds the admin permission", 1, data.Permissions{"reading_list:admin"}, true},
+		{"key scoped below admin", 1, data.Permissions{"reading_list:read"}, false},
+		{"owner with a narrow key", 2, data.Permissions{"reading_list:read"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r = a.contextSetUser(r, user, data.Permissions{"reading_list:read", "reading_list:admin"})
+			r = a.contextSetAPIKey(r, &data.APIKey{Permissions: tt.scope})
+
+			if got := a.isOwnerOrAdmin(r, tt.ownerID, "reading_list:admin"); got != tt.want {
+				t.Errorf("got %t; want %t", got, tt.want)
+			}
+		})
+	}
+}
@@ -46,6 +46,12 @@ func (a *applicationDependences) createReadingListHandler(w http.ResponseWriter,
 		return
 	}
 
+	//only the user themselves or a reading list admin may create a list for a user
+	if !a.isOwnerOrAdmin(r, incomingData.CreatedBy, "reading_list:admin") {
+		a.notPermittedResponse(w, r)
+		return
+	}
+
 	//create the list in the database
 	err = a.readingListModel.CreateReadingList(reading_List)
 	if err != nil {
@@ -18,7 +18,6 @@ func (a *applicationDependences) addReviewForBooksHandler(w http.ResponseWriter,
 	}
 
 	var incomingData struct {
-		UserID     int64   `json:"user_id"`
 		Rating     float32 `json:"rating"`
 		ReviewText string  `json:"review_text"`
 	}
@@ -30,9 +29,10 @@ func (a *applicationDependences) addReviewForBooksHandler(w http.ResponseWriter,
 
 	}
 
+	//reviews are always written by the authenticated user
 	review := &data.Review{
 		Book_ID:    book_id,
-		User_ID:    incomingData.UserID,
+		User_ID:    a.contextGetUser(r).ID,
 		Rating:     incomingData.Rating,
 		ReviewText: incomingData.ReviewText,
 	}
@@ -45,28 +45,108 @@ func (a *applicationDependences) addReviewForBooksHandler(w http.ResponseWriter,
 		return
 	}
 
+	err = a.bookModel.BookExists(book_id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
 	err = a.reviewModel.InsertReview(review)
 	if err != nil {
-		a.serverErrorResponse(w, r, err)
+		switch {
+		case errors.Is(err, data.ErrDuplicateReview):
+			a.duplicateReviewResponse(w, r, book_id)
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
 		return
 	}
 
 	//setting location header
 	headers := make(http.Header)
-	headers.Set("Location", fmt.Sprintf("/api/v1/books/%d/reviews", review.ID))
+	headers.Set("Location", fmt.Sprintf("/api/v1/books/%d/reviews", review.Book_ID))
 
 	//send 201 code and wata
 	data := envelope{
 		"review": review,
 	}
 
-	err = a.writeJSON(w, http.StatusOK, data, nil)
+	err = a.writeJSON(w, http.StatusCreated, data, headers)
 	if err != nil {
 		a.serverErrorResponse(w, r, err)
 		return
 	}
 }
 
+// create or replace the review of the authenticated user for a book
+func (a *applicationDependences) upsertMyReviewHandler(w http.ResponseWriter, r *http.Request) {
+	book_id, err := a.readIDParam(r, "b_id")
+	if err != nil || book_id < 1 {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	var incomingData struct {
+		Rating     float32 `json:"rating"`
+		ReviewText string  `json:"review_text"`
+	}
+
+	err = a.readJSON(w, r, &incomingData)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	review := &data.Review{
+		Book_ID:    book_id,
+		User_ID:    a.contextGetUser(r).ID,
+		Rating:     incomingData.Rating,
+		ReviewText: incomingData.ReviewText,
+	}
+
+	v := validator.New()
+	data.ValidateReview(v, review)
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	//the model reports an unknown book while locking it
+	created, err := a.reviewModel.UpsertReview(review)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	status := http.StatusOK
+	headers := make(http.Header)
+	if created {
+		status = http.StatusCreated
+		headers.Set("Location", fmt.Sprintf("/api/v1/books/%d/reviews", review.Book_ID))
+	}
+
+	data := envelope{
+		"review": review,
+	}
+
+	err = a.writeJSON(w, status, data, headers)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
 func (a *applicationDependences) deleteReviewForBookHandler(w http.ResponseWriter, r *http.Request) {
 	//retch review id to delete
 	revID, err := a.readIDParam(r, "r_id")
@@ -100,6 +180,7 @@ func (a *applicationDependences) deleteReviewForBookHandler(w http.ResponseWrite
 func (a *applicationDependences) listAllReviewsForBookHandler(w http.ResponseWriter, r *http.Request) {
 	//to hold query parameters
 	var queryParameterData struct {
+		MinRating int
 		data.Fileters
 	}
 
@@ -111,15 +192,21 @@ func (a *applicationDependences) listAllReviewsForBookHandler(w http.ResponseWri
 	queryParameterData.Fileters.Page = a.getSingleIntigerParameter(queryParameter, "page", 1, v)
 	queryParameterData.Fileters.PageSize = a.getSingleIntigerParameter(queryParameter, "page_size", 10, v)
 	queryParameterData.Fileters.Sorting = a.getSingleQueryParameter(queryParameter, "sorting", "id")
-	queryParameterData.Fileters.SortSafeList = []string{"id", "-id"}
+	queryParameterData.Fileters.SortSafeList = []string{"id", "rating", "created_at", "helpful_count",
+		"-id", "-rating", "-created_at", "-helpful_count"}
+	//0 means every rating
+	queryParameterData.MinRating = a.getSingleIntigerParameter(queryParameter, "min_rating", 0, v)
 
 	data.ValidateFilters(v, queryParameterData.Fileters)
+	if queryParameterData.MinRating != 0 {
+		v.Check(queryParameterData.MinRating >= 1 && queryParameterData.MinRating <= 5, "min_rating", "must be a number between 1 and 5")
+	}
 	if !v.IsEmpty() {
 		a.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	book_id, err := a.readIDParam(r, "rb_id")
+	book_id, err := a.readIDParam(r, "b_id")
 	if err != nil || book_id < 1 {
 		a.notFoundResponse(w, r)
 		return
@@ -127,11 +214,16 @@ func (a *applicationDependences) listAllReviewsForBookHandler(w http.ResponseWri
 
 	err = a.bookModel.BookExists(book_id)
 	if err != nil {
-		a.notFoundResponse(w, r)
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
 		return
 	}
 
-	reviews, metadata, err := a.reviewModel.GetAllReviews(queryParameterData.Fileters)
+	reviews, metadata, err := a.reviewModel.GetAllForBook(book_id, queryParameterData.MinRating, a.reviewViewer(r), queryParameterData.Fileters)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -227,7 +319,7 @@ func (a *applicationDependences) fetchReviewByIdHandler(w http.ResponseWriter, r
 		return
 	}
 
-	reviews, err := a.reviewModel.GetAllByUserID(pid)
+	reviews, err := a.reviewModel.GetAllByUserID(pid, a.reviewViewer(r))
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -250,3 +342,78 @@ func (a *applicationDependences) fetchReviewByIdHandler(w http.ResponseWriter, r
 	}
 
 }
+
+// mark a review as helpful, voting again is harmless
+func (a *applicationDependences) addHelpfulVoteHandler(w http.ResponseWriter, r *http.Request) {
+	a.changeHelpfulVote(w, r, true)
+}
+
+// take back a helpful vote, removing a vote that does not exist is harmless
+func (a *applicationDependences) removeHelpfulVoteHandler(w http.ResponseWriter, r *http.Request) {
+	a.changeHelpfulVote(w, r, false)
+}
+
+func (a *applicationDependences) changeHelpfulVote(w http.ResponseWriter, r *http.Request, helpful bool) {
+	review_id, err := a.readIDParam(r, "r_id")
+	if err != nil || review_id < 1 {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	review, err := a.reviewModel.GetByID(review_id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	//reviews the user cannot see do not exist for them
+	if !a.canSeeReview(r, review) {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	user := a.contextGetUser(r)
+	if review.User_ID == user.ID {
+		a.ownReviewVoteResponse(w, r)
+		return
+	}
+
+	var count int32
+	var changed bool
+	if helpful {
+		count, changed, err = a.reviewModel.AddHelpfulVote(review_id, user.ID)
+	} else {
+		count, changed, err = a.reviewModel.RemoveHelpfulVote(review_id, user.ID)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	//201 only when a new vote was recorded
+	status := http.StatusOK
+	if helpful && changed {
+		status = http.StatusCreated
+	}
+
+	data := envelope{
+		"review_id":     review.ID,
+		"helpful_count": count,
+		"voted":         helpful,
+	}
+
+	err = a.writeJSON(w, status, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
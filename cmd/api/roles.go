@@ -0,0 +1,322 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/abner-tech/Test3-Api.git/internal/data"
+	"github.com/abner-tech/Test3-Api.git/internal/validator"
+)
+
+// list all roles and the permissions each one grants
+func (a *applicationDependences) listRolesHandler(w http.ResponseWriter, r *http.Request) {
+	roles, err := a.roleModel.GetAll()
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	data := envelope{
+		"roles": roles,
+	}
+
+	err = a.writeJSON(w, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// fetch a specific role using id
+func (a *applicationDependences) getRoleHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := a.readIDParam(r, "role_id")
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	role, err := a.roleModel.GetByID(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	data := envelope{
+		"role": role,
+	}
+
+	err = a.writeJSON(w, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// create a new role with an optional initial set of permissions
+func (a *applicationDependences) createRoleHandler(w http.ResponseWriter, r *http.Request) {
+	var incomingData struct {
+		Name        string   `json:"name"`
+		Permissions []string `json:"permissions"`
+	}
+
+	err := a.readJSON(w, r, &incomingData)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	role := &data.Role{
+		Name:        incomingData.Name,
+		Permissions: incomingData.Permissions,
+	}
+
+	v := validator.New()
+	data.ValidateRole(v, role)
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = a.roleModel.Insert(role)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateRoleName):
+			v.AddError("name", "a role with this name already exists")
+			a.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrUnknownPermission):
+			v.AddError("permissions", err.Error())
+			a.failedValidationResponse(w, r, v.Errors)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	//reload so the response shows the permissions that were actually mapped
+	role, err = a.roleModel.GetByID(role.ID)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/api/v1/admin/roles/%d", role.ID))
+
+	data := envelope{
+		"role": role,
+	}
+
+	err = a.writeJSON(w, http.StatusCreated, data, headers)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// delete a role, users holding it lose the permissions it granted
+func (a *applicationDependences) deleteRoleHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := a.readIDParam(r, "role_id")
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	err = a.roleModel.Delete(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	//any number of users may have held the role
+	a.authCache.Clear()
+
+	data := envelope{
+		"message": "role deleted sucessfully",
+	}
+
+	err = a.writeJSON(w, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// add or remove permission codes on a role, depending on the request method
+func (a *applicationDependences) updateRolePermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := a.readIDParam(r, "role_id")
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	var incomingData struct {
+		Permissions []string `json:"permissions"`
+	}
+
+	err = a.readJSON(w, r, &incomingData)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(len(incomingData.Permissions) > 0, "permissions", "must contain at least one permission code")
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	//make sure the role exists before changing it
+	_, err = a.roleModel.GetByID(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if r.Method == http.MethodDelete {
+		err = a.roleModel.RemovePermissions(id, incomingData.Permissions...)
+	} else {
+		err = a.roleModel.AddPermissions(id, incomingData.Permissions...)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrUnknownPermission):
+			v.AddError("permissions", err.Error())
+			a.failedValidationResponse(w, r, v.Errors)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	//any number of users may hold the role
+	a.authCache.Clear()
+
+	role, err := a.roleModel.GetByID(id)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	data := envelope{
+		"role": role,
+	}
+
+	err = a.writeJSON(w, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// assign a role to a user
+func (a *applicationDependences) assignRoleHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := a.readIDParam(r, "role_id")
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	var incomingData struct {
+		UserID int64 `json:"user_id"`
+	}
+
+	err = a.readJSON(w, r, &incomingData)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	role, err := a.roleModel.GetByID(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	//check if the user the role is for does exist
+	err = a.userModel.UserExist(incomingData.UserID)
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	//entry is nil when the user already had the role
+	entry, err := a.roleModel.AssignToUserAudited(a.contextGetUser(r).ID, incomingData.UserID, role)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+	a.authCache.DeleteUser(incomingData.UserID)
+
+	data := envelope{
+		"message": fmt.Sprintf("role %s assigned to user %d", role.Name, incomingData.UserID),
+		"audit":   entry,
+	}
+
+	err = a.writeJSON(w, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// take a role away from a user
+func (a *applicationDependences) unassignRoleHandler(w http.ResponseWriter, r *http.Request) {
+	roleID, err := a.readIDParam(r, "role_id")
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	userID, err := a.readIDParam(r, "u_id")
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	role, err := a.roleModel.GetByID(roleID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	entry, err := a.roleModel.RemoveFromUser(a.contextGetUser(r).ID, userID, role)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	a.authCache.DeleteUser(userID)
+
+	data := envelope{
+		"message": "role removed from user sucessfully",
+		"audit":   entry,
+	}
+
+	err = a.writeJSON(w, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
@@ -23,9 +23,18 @@ func (a *applicationDependences) routes() http.Handler {
 	router.HandlerFunc(http.MethodPost, "/api/v1/register/user", a.registerUserHandler)
 	// User activation and authentication
 	router.HandlerFunc(http.MethodPut, "/api/v1/users/activated", a.activateUserHandler)
-	router.HandlerFunc(http.MethodPost, "/api/v1/tokens/authentication", a.createAuthenticationTokenHandler)
-	router.HandlerFunc(http.MethodPost, "/api/v1/tokens/password-reset", a.createPasswordResetHandler)
-	router.HandlerFunc(http.MethodPut, "/api/v1/tokens/password-reset", a.userPasswordReset)
+	router.HandlerFunc(http.MethodPost, "/api/v1/tokens/activation", a.authRateLimiting(a.createActivationTokenHandler))
+	router.HandlerFunc(http.MethodPost, "/api/v1/tokens/authentication", a.authRateLimiting(a.createAuthenticationTokenHandler))
+	router.HandlerFunc(http.MethodGet, "/api/v1/tokens/authentication", a.requireAuthenticatedUser(a.requireSession(a.listAuthenticationTokensHandler)))
+	router.HandlerFunc(http.MethodDelete, "/api/v1/tokens/authentication", a.requireAuthenticatedUser(a.deleteAuthenticationTokenHandler))
+	router.HandlerFunc(http.MethodDelete, "/api/v1/tokens/authentication/all", a.requireAuthenticatedUser(a.requireSession(a.deleteAllAuthenticationTokensHandler)))
+	router.HandlerFunc(http.MethodPost, "/api/v1/tokens/two-factor", a.authRateLimiting(a.verifyTwoFactorLoginHandler))
+	router.HandlerFunc(http.MethodGet, "/api/v1/oidc", a.listOIDCProvidersHandler)
+	router.HandlerFunc(http.MethodGet, "/api/v1/oidc/:provider/login", a.authRateLimiting(a.oidcLoginHandler))
+	router.HandlerFunc(http.MethodGet, "/api/v1/oidc/:provider/callback", a.authRateLimiting(a.oidcCallbackHandler))
+	router.HandlerFunc(http.MethodPost, "/api/v1/tokens/refresh", a.authRateLimiting(a.refreshAuthenticationTokenHandler))
+	router.HandlerFunc(http.MethodPost, "/api/v1/tokens/password-reset", a.authRateLimiting(a.createPasswordResetHandler))
+	router.HandlerFunc(http.MethodPut, "/api/v1/tokens/password-reset", a.authRateLimiting(a.userPasswordReset))
 
 	// User's reading lists
 	router.HandlerFunc(http.MethodGet, "/api/v1/user/:u_id/lists", a.requireActivatedUser(a.requirePermission("reading_list:read", a.listUsersReadingLists)))
@@ -34,13 +43,25 @@ func (a *applicationDependences) routes() http.Handler {
 	router.HandlerFunc(http.MethodGet, "/api/v1/lists", a.requireActivatedUser(a.requirePermission("reading_list:read", a.listAllReadingListHandler)))
 	router.HandlerFunc(http.MethodGet, "/api/v1/lists/:rl_id", a.requireActivatedUser(a.requirePermission("reading_list:read", a.getSpecificReadingListHandler)))
 	router.HandlerFunc(http.MethodPost, "/api/v1/lists", a.requireActivatedUser(a.requirePermission("reading_list:write", a.createReadingListHandler)))
-	router.HandlerFunc(http.MethodPut, "/api/v1/lists/:rl_id", a.requireActivatedUser(a.requirePermission("reading_list:write", a.updateReadingListhandler)))
-	router.HandlerFunc(http.MethodDelete, "/api/v1/lists/:rl_id", a.requireActivatedUser(a.requirePermission("reading_list:write", a.deleteReadingListHander)))
-	router.HandlerFunc(http.MethodPost, "/api/v1/lists/:rl_id/books", a.requireActivatedUser(a.requirePermission("reading_list:write", a.addBookToReadingListHandler)))
-	router.HandlerFunc(http.MethodDelete, "/api/v1/lists/:rl_id/books", a.requireActivatedUser(a.requirePermission("reading_list:write", a.deleteBookInReadingListHandler)))
+	router.HandlerFunc(http.MethodPut, "/api/v1/lists/:rl_id", a.requireActivatedUser(a.requirePermission("reading_list:write", a.requireOwnership("reading_list:admin", a.readingListOwner, a.updateReadingListhandler))))
+	router.HandlerFunc(http.MethodDelete, "/api/v1/lists/:rl_id", a.requireActivatedUser(a.requirePermission("reading_list:write", a.requireOwnership("reading_list:admin", a.readingListOwner, a.deleteReadingListHander))))
+	router.HandlerFunc(http.MethodPost, "/api/v1/lists/:rl_id/books", a.requireActivatedUser(a.requirePermission("reading_list:write", a.requireOwnership("reading_list:admin", a.readingListOwner, a.addBookToReadingListHandler))))
+	router.HandlerFunc(http.MethodDelete, "/api/v1/lists/:rl_id/books", a.requireActivatedUser(a.requirePermission("reading_list:write", a.requireOwnership("reading_list:admin", a.readingListOwner, a.deleteBookInReadingListHandler))))
 
 	// USER SECTION
-	router.HandlerFunc(http.MethodGet, "/api/v1/users/:uid", a.requireActivatedUser(a.requirePermission("users:read", a.listUserProfileHandler)))
+	router.HandlerFunc(http.MethodGet, "/api/v1/users/:uid", a.routeMe("uid", a.requireActivatedUser(a.showCurrentUserHandler), a.requireActivatedUser(a.requirePermission("users:read", a.listUserProfileHandler))))
+	router.HandlerFunc(http.MethodPatch, "/api/v1/users/me", a.requireActivatedUser(a.requireSession(a.updateCurrentUserHandler)))
+	router.HandlerFunc(http.MethodDelete, "/api/v1/users/me", a.requireActivatedUser(a.requireSession(a.deleteCurrentUserHandler)))
+	router.HandlerFunc(http.MethodPost, "/api/v1/users/me/two-factor", a.requireActivatedUser(a.requireSession(a.enableTwoFactorHandler)))
+	router.HandlerFunc(http.MethodPut, "/api/v1/users/me/two-factor/confirmed", a.requireActivatedUser(a.requireSession(a.confirmTwoFactorHandler)))
+	router.HandlerFunc(http.MethodDelete, "/api/v1/users/me/two-factor", a.requireActivatedUser(a.requireSession(a.disableTwoFactorHandler)))
+	router.HandlerFunc(http.MethodPost, "/api/v1/users/me/api-keys", a.requireActivatedUser(a.requireSession(a.createAPIKeyHandler)))
+	router.HandlerFunc(http.MethodGet, "/api/v1/users/:uid/api-keys", a.routeMe("uid", a.requireActivatedUser(a.requireSession(a.listAPIKeysHandler)), a.notFoundResponse))
+	router.HandlerFunc(http.MethodDelete, "/api/v1/users/me/api-keys/:key_id", a.requireActivatedUser(a.requireSession(a.deleteAPIKeyHandler)))
+	router.HandlerFunc(http.MethodGet, "/api/v1/users/:uid/export", a.routeMe("uid", a.requireActivatedUser(a.requireSession(a.exportCurrentUserHandler)), a.notFoundResponse))
+	router.HandlerFunc(http.MethodPut, "/api/v1/users/me/email", a.requireActivatedUser(a.requireSession(a.requestEmailChangeHandler)))
+	router.HandlerFunc(http.MethodPut, "/api/v1/users/me/password", a.requireActivatedUser(a.requireSession(a.changePasswordHandler)))
+	router.HandlerFunc(http.MethodPut, "/api/v1/users/email/confirmed", a.confirmEmailChangeHandler)
 
 	// BOOKS SECTION
 	router.HandlerFunc(http.MethodGet, "/api/v1/books", a.requireActivatedUser(a.requirePermission("books:read", a.listAllBooksHandler)))
@@ -52,10 +73,35 @@ func (a *applicationDependences) routes() http.Handler {
 
 	// REVIEWS SECTION
 	router.HandlerFunc(http.MethodPost, "/api/v1/books/:r_id/reviews", a.requireActivatedUser(a.requirePermission("reviews:write", a.addReviewForBooksHandler)))
-	router.HandlerFunc(http.MethodDelete, "/api/v1/reviews/:r_id", a.requireActivatedUser(a.requirePermission("reviews:write", a.deleteReviewForBookHandler)))
-	router.HandlerFunc(http.MethodGet, "/api/v_1/books/:rb_id/reviews", a.requireActivatedUser(a.requirePermission("reviews:read", a.listAllReviewsForBookHandler)))
-	router.HandlerFunc(http.MethodPut, "/api/v1/reviews/:r_id", a.requireActivatedUser(a.requirePermission("reviews:write", a.updateReviewForBookHandler)))
+	router.HandlerFunc(http.MethodDelete, "/api/v1/reviews/:r_id", a.requireActivatedUser(a.requirePermission("reviews:write", a.requireOwnership("reviews:admin", a.reviewOwner, a.deleteReviewForBookHandler))))
+	router.HandlerFunc(http.MethodPost, "/api/v1/reviews/:r_id/helpful", a.requireActivatedUser(a.requirePermission("reviews:write", a.addHelpfulVoteHandler)))
+	router.HandlerFunc(http.MethodDelete, "/api/v1/reviews/:r_id/helpful", a.requireActivatedUser(a.requirePermission("reviews:write", a.removeHelpfulVoteHandler)))
+	router.HandlerFunc(http.MethodGet, "/api/v1/books/:b_id/reviews", a.requireActivatedUser(a.requirePermission("reviews:read", a.listAllReviewsForBookHandler)))
+	router.HandlerFunc(http.MethodGet, "/api/v_1/books/:b_id/reviews", a.deprecatedAlias("/api/v_1/", "/api/v1/", a.requireActivatedUser(a.requirePermission("reviews:read", a.listAllReviewsForBookHandler))))
+	router.HandlerFunc(http.MethodPut, "/api/v1/books/:b_id/reviews/me", a.requireActivatedUser(a.requirePermission("reviews:write", a.upsertMyReviewHandler)))
+	router.HandlerFunc(http.MethodPut, "/api/v1/reviews/:r_id", a.requireActivatedUser(a.requirePermission("reviews:write", a.requireOwnership("reviews:admin", a.reviewOwner, a.updateReviewForBookHandler))))
 	router.HandlerFunc(http.MethodGet, "/api/v1/user/:u_id/reviews", a.requireActivatedUser(a.requirePermission("reviews:read", a.fetchReviewByIdHandler)))
 
+	// MODERATION SECTION
+	router.HandlerFunc(http.MethodPost, "/api/v1/reviews/:r_id/reports", a.requireActivatedUser(a.requirePermission("reviews:read", a.reportReviewHandler)))
+	router.HandlerFunc(http.MethodGet, "/api/v1/moderation/reviews", a.requireActivatedUser(a.requirePermission("reviews:moderate", a.listModerationQueueHandler)))
+	router.HandlerFunc(http.MethodGet, "/api/v1/moderation/reviews/:r_id", a.requireActivatedUser(a.requirePermission("reviews:moderate", a.showModeratedReviewHandler)))
+	router.HandlerFunc(http.MethodPost, "/api/v1/moderation/reviews/:r_id/actions", a.requireActivatedUser(a.requirePermission("reviews:moderate", a.moderateReviewHandler)))
+
+	// ADMIN SECTION
+	router.HandlerFunc(http.MethodGet, "/api/v1/admin/roles", a.requireActivatedUser(a.requirePermission("users:write", a.listRolesHandler)))
+	router.HandlerFunc(http.MethodPost, "/api/v1/admin/roles", a.requireActivatedUser(a.requirePermission("users:write", a.createRoleHandler)))
+	router.HandlerFunc(http.MethodGet, "/api/v1/admin/roles/:role_id", a.requireActivatedUser(a.requirePermission("users:write", a.getRoleHandler)))
+	router.HandlerFunc(http.MethodDelete, "/api/v1/admin/roles/:role_id", a.requireActivatedUser(a.requirePermission("users:write", a.deleteRoleHandler)))
+	router.HandlerFunc(http.MethodPost, "/api/v1/admin/roles/:role_id/permissions", a.requireActivatedUser(a.requirePermission("users:write", a.updateRolePermissionsHandler)))
+	router.HandlerFunc(http.MethodDelete, "/api/v1/admin/roles/:role_id/permissions", a.requireActivatedUser(a.requirePermission("users:write", a.updateRolePermissionsHandler)))
+	router.HandlerFunc(http.MethodPost, "/api/v1/admin/roles/:role_id/users", a.requireActivatedUser(a.requirePermission("users:write", a.assignRoleHandler)))
+	router.HandlerFunc(http.MethodDelete, "/api/v1/admin/roles/:role_id/users/:u_id", a.requireActivatedUser(a.requirePermission("users:write", a.unassignRoleHandler)))
+	router.HandlerFunc(http.MethodGet, "/api/v1/admin/permissions", a.requireActivatedUser(a.requirePermission("users:write", a.listPermissionsHandler)))
+	router.HandlerFunc(http.MethodGet, "/api/v1/admin/users/:u_id/permissions", a.requireActivatedUser(a.requirePermission("users:write", a.listUserPermissionsHandler)))
+	router.HandlerFunc(http.MethodPost, "/api/v1/admin/users/:u_id/permissions", a.requireActivatedUser(a.requirePermission("users:write", a.updateUserPermissionsHandler)))
+	router.HandlerFunc(http.MethodDelete, "/api/v1/admin/users/:u_id/permissions", a.requireActivatedUser(a.requirePermission("users:write", a.updateUserPermissionsHandler)))
+	router.HandlerFunc(http.MethodGet, "/api/v1/admin/users/:u_id/permissions/audit", a.requireActivatedUser(a.requirePermission("users:write", a.listUserPermissionsAuditHandler)))
+
 	return a.enableCORS(a.recoverPanic(a.rateLimiting(a.authenticate(router))))
 }
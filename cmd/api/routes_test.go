@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/abner-tech/Test3-Api.git/internal/data"
+)
+
+// an activated user with the given roles, deleted (with everything they own) when the test ends
+func newRouteTestUser(t *testing.T, a *applicationDependences, name string, roles ...string) *data.User {
+	t.Helper()
+
+	suffix := time.Now().UnixNano()
+	user := &data.User{
+		Username:  fmt.Sprintf("%s%d", name, suffix),
+		Email:     fmt.Sprintf("%s%d@example.com", name, suffix),
+		Activated: true,
+	}
+	err := a.userModel.SetPassword(user, "correct horse battery staple")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = a.userModel.Insert(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_, err := a.userModel.DB.Exec(`DELETE FROM users WHERE id = $1`, user.ID)
+		if err != nil {
+			t.Error(err)
+		}
+	})
+
+	if len(roles) > 0 {
+		err = a.roleModel.AssignToUser(user.ID, roles...)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return user
+}
+
+// every route that changes a review or a reading list must refuse a user who does not own it
+func TestRoutesRequireOwnership(t *testing.T) {
+	a := newTestDBApplication(t, serverConfig{})
+	db := a.userModel.DB
+
+	owner := newRouteTestUser(t, a, "owner", data.RoleContributor)
+	//holds the write permissions, but not the admin ones
+	other := newRouteTestUser(t, a, "other", data.RoleContributor)
+
+	var bookID, listID, reviewID int64
+	err := db.QueryRow(`
+	INSERT INTO books (title, authors, isbn, genre)
+	VALUES ('Ownership', '{Tester}', $1, '{Test}')
+	RETURNING id`, fmt.Sprint(time.Now().UnixNano()%1e13)).Scan(&bookID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_, err := db.Exec(`DELETE FROM books WHERE id = $1`, bookID)
+		if err != nil {
+			t.Error(err)
+		}
+	})
+
+	err = db.QueryRow(`INSERT INTO reading_lists (name, created_by) VALUES ('mine', $1) RETURNING id`, owner.ID).Scan(&listID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	review := &data.Review{Book_ID: bookID, User_ID: owner.ID, Rating: 4, ReviewText: "mine"}
+	err = a.reviewModel.InsertReview(review)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reviewID = int64(review.ID)
+
+	token, err := a.tokenModel.New(other.ID, time.Hour, data.ScopeAuthentication)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := a.routes()
+	send := func(method, path string) *httptest.ResponseRecorder {
+		body := fmt.Sprintf(`{"book_id": %d, "name": "theirs", "rating": 1, "review_text": "theirs"}`, bookID)
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		r.Header.Set("Authorization", "Bearer "+token.PlainText)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w
+	}
+
+	tests := []struct {
+		method string
+		path   string
+	}{
+		{http.MethodPut, fmt.Sprintf("/api/v1/lists/%d", listID)},
+		{http.MethodDelete, fmt.Sprintf("/api/v1/lists/%d", listID)},
+		{http.MethodPost, fmt.Sprintf("/api/v1/lists/%d/books", listID)},
+		{http.MethodDelete, fmt.Sprintf("/api/v1/lists/%d/books", listID)},
+		{http.MethodPut, fmt.Sprintf("/api/v1/reviews/%d", reviewID)},
+		{http.MethodDelete, fmt.Sprintf("/api/v1/reviews/%d", reviewID)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			w := send(tt.method, tt.path)
+			if w.Code != http.StatusForbidden {
+				t.Errorf("got status %d; want %d: %s", w.Code, http.StatusForbidden, w.Body)
+			}
+		})
+	}
+
+	//account routes only exist for the caller's own account, another user's id is never routed
+	for _, method := range []string{http.MethodPut, http.MethodPatch, http.MethodDelete} {
+		path := fmt.Sprintf("/api/v1/users/%d", owner.ID)
+		t.Run(method+" "+path, func(t *testing.T) {
+			w := send(method, path)
+			if w.Code != http.StatusMethodNotAllowed {
+				t.Errorf("got status %d; want %d: %s", w.Code, http.StatusMethodNotAllowed, w.Body)
+			}
+		})
+	}
+
+	//the owner's things are untouched
+	_, err = a.readingListModel.GetByID(listID)
+	if err != nil {
+		t.Errorf("reading list: %v", err)
+	}
+	stored, err := a.reviewModel.GetByID(reviewID)
+	if err != nil {
+		t.Fatalf("review: %v", err)
+	}
+	if stored.ReviewText != "mine" {
+		t.Errorf("review text changed to %q", stored.ReviewText)
+	}
+}
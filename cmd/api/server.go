@@ -25,6 +25,13 @@ func (a *applicationDependences) serve() error {
 	//create a channel to kepp track of any errors during the shutdown process
 	shutdownError := make(chan error)
 
+	//background workers stop when this context is cancelled during shutdown
+	workers, stopWorkers := context.WithCancel(context.Background())
+	defer stopWorkers()
+	a.startTokenJanitor(workers)
+	a.startAccountJanitor(workers)
+	a.authCache.startCleanup(workers, time.Minute)
+
 	//crete a goroutine that runs in the background listining to the shutdown signals
 	go func() {
 
@@ -52,6 +59,7 @@ func (a *applicationDependences) serve() error {
 
 		//waiting for background tasks to finish
 		a.logger.Info("completing background tasks", "address", apiServer.Addr)
+		stopWorkers()
 		a.wg.Wait()
 		shutdownError <- nil //sucessfull shutdown
 	}()
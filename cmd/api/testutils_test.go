@@ -0,0 +1,80 @@
+package main
+
+import (
+	"database/sql"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/abner-tech/Test3-Api.git/internal/data"
+	_ "github.com/lib/pq"
+)
+
+// an application with nothing but a silent logger, enough for handlers that don't reach the database
+func newTestApplication(t *testing.T) *applicationDependences {
+	t.Helper()
+	return &applicationDependences{
+		logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+}
+
+// run the handler for a request made by user, who holds permissions
+func serveAs(a *applicationDependences, handler http.HandlerFunc, user *data.User, permissions data.Permissions) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r = a.contextSetUser(r, user, permissions)
+	w := httptest.NewRecorder()
+	handler(w, r)
+	return w
+}
+
+/*
+an application backed by the database named by TEST3_DB_DSN, which must be
+migrated to the latest version. The test is skipped when it is not set
+*/
+func newTestDBApplication(t *testing.T, settings serverConfig) *applicationDependences {
+	t.Helper()
+
+	dsn := os.Getenv("TEST3_DB_DSN")
+	if dsn == "" {
+		t.Skip("TEST3_DB_DSN not set")
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Ping()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	settings.auth.accessTTL = 15 * time.Minute
+	settings.auth.refreshTTL = time.Hour
+
+	a := newTestApplication(t)
+	a.config = settings
+	a.userModel = data.UserModel{DB: db, Hashing: data.DefaultPasswordHashing()}
+	a.tokenModel = data.TokenModel{DB: db}
+	a.permisionsModel = data.PermissionsModel{DB: db}
+	a.roleModel = data.RoleModel{DB: db}
+	a.identityModel = data.IdentityModel{DB: db}
+	a.bookModel = data.BookModel{DB: db}
+	a.readingListModel = data.ReadingListModel{DB: db}
+	a.reviewModel = data.ReviewModel{DB: db}
+	a.moderationModel = data.ModerationModel{DB: db}
+	a.apiKeyModel = data.APIKeyModel{DB: db}
+	a.twoFactorModel = data.TwoFactorModel{DB: db}
+	a.oidcProviders = newOIDCProviders(settings)
+	a.unknownLogins = newUnknownLogins()
+
+	//background work (emails) has to finish before the database goes away
+	t.Cleanup(func() {
+		a.wg.Wait()
+		db.Close()
+	})
+	return a
+}
@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/abner-tech/Test3-Api.git/internal/data"
+	"github.com/abner-tech/Test3-Api.git/internal/jwt"
 	"github.com/abner-tech/Test3-Api.git/internal/validator"
 )
 
@@ -35,6 +36,11 @@ func (a *applicationDependences) createAuthenticationTokenHandler(w http.Respons
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
+			//answer exactly like a wrong password, lockout included, so unknown emails cannot be told apart
+			if a.refuseUntil(w, r, a.unknownLogins.lockedUntil(incomingData.Email)) {
+				return
+			}
+			a.unknownLogins.recordFailure(incomingData.Email, a.lockoutDelay)
 			a.invalidCredentialResponse(w, r)
 		default:
 			a.serverErrorResponse(w, r, err)
@@ -42,6 +48,11 @@ func (a *applicationDependences) createAuthenticationTokenHandler(w http.Respons
 		return
 	}
 
+	//too many recent failures, we do not even look at the password
+	if a.loginLocked(w, r, user) {
+		return
+	}
+
 	//user is found so we verify password
 	match, err := user.Password.Matches(incomingData.Password)
 	if err != nil {
@@ -51,11 +62,81 @@ func (a *applicationDependences) createAuthenticationTokenHandler(w http.Respons
 
 	//wrong password
 	if !match {
+		err = a.recordFailedLogin(user)
+		if err != nil {
+			a.serverErrorResponse(w, r, err)
+			return
+		}
 		a.invalidCredentialResponse(w, r)
 		return
 	}
 
-	token, err := a.tokenModel.New(user.ID, 24*time.Hour, data.ScopeAuthentication)
+	//the hash was made with older settings, we have the plaintext now so upgrade it.
+	//a failure here only means we try again on the next login
+	if a.userModel.NeedsRehash(user) {
+		err = a.userModel.RehashPassword(user, incomingData.Password)
+		if err != nil {
+			a.logger.Error(err.Error(), "task", "password rehash", "user_id", user.ID)
+		}
+	}
+
+	a.loginUser(w, r, user)
+}
+
+/*
+the user passed the first step of a login (password or an external provider).
+With two-factor authentication that only buys a short-lived challenge token,
+otherwise the login is complete
+*/
+func (a *applicationDependences) loginUser(w http.ResponseWriter, r *http.Request, user *data.User) {
+	if user.TwoFactor {
+		challenge, err := a.tokenModel.New(user.ID, twoFactorChallengeTTL, data.ScopeTwoFactorChallenge)
+		if err != nil {
+			a.serverErrorResponse(w, r, err)
+			return
+		}
+
+		data := envelope{
+			"two_factor_required": true,
+			"challenge_token":     challenge,
+		}
+
+		err = a.writeJSON(w, http.StatusAccepted, data, nil)
+		if err != nil {
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	a.completeLogin(w, r, user)
+}
+
+// the user has proven who they are, start a new login and hand out its tokens
+func (a *applicationDependences) completeLogin(w http.ResponseWriter, r *http.Request, user *data.User) {
+	err := a.userModel.ResetFailedLogins(user.ID)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	//logging in during the grace period keeps the account
+	cancelled, err := a.userModel.CancelDeletion(user.ID)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+	if cancelled {
+		a.logger.Info("account deletion cancelled", "user_id", user.ID)
+	}
+
+	//every login starts a new token family
+	family, err := data.NewTokenFamily()
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	token, refreshToken, err := a.issueTokenPair(user, r.UserAgent(), family)
 	if err != nil {
 		a.serverErrorResponse(w, r, err)
 		return
@@ -63,6 +144,7 @@ func (a *applicationDependences) createAuthenticationTokenHandler(w http.Respons
 
 	data := envelope{
 		"authentication_token": token,
+		"refresh_token":        refreshToken,
 	}
 
 	err = a.writeJSON(w, http.StatusCreated, data, nil)
@@ -92,11 +174,54 @@ func (a *applicationDependences) createPasswordResetHandler(w http.ResponseWrite
 		return
 	}
 
+	//one reset email per address every few minutes, so an account cannot be flooded
+	if !a.resetMails.Allow(incomingData.Email) {
+		a.rateLimitExceededResponse(w, r)
+		return
+	}
+
+	/*
+		when accounts are hidden the lookup happens after we have answered, so
+		the response and its timing are the same whether the address exists or not
+	*/
+	if a.config.auth.hideAccounts {
+		email := incomingData.Email
+		a.background(func() {
+			user, err := a.userModel.GetByEmail(email)
+			if err != nil {
+				if !errors.Is(err, data.ErrRecordNotFound) {
+					a.logger.Error(err.Error())
+				}
+				return
+			}
+
+			err = a.sendPasswordResetToken(user)
+			if err != nil {
+				a.logger.Error(err.Error())
+			}
+		})
+
+		data := envelope{
+			"message": "if an account with this email address exists, a temporary token has been sent to it",
+		}
+
+		err = a.writeJSON(w, http.StatusAccepted, data, nil)
+		if err != nil {
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
 	//checking if user exist for email
 	user, err := a.userModel.GetByEmail(incomingData.Email)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
+			//answer exactly like a wrong password, lockout included, so unknown emails cannot be told apart
+			if a.refuseUntil(w, r, a.unknownLogins.lockedUntil(incomingData.Email)) {
+				return
+			}
+			a.unknownLogins.recordFailure(incomingData.Email, a.lockoutDelay)
 			a.invalidCredentialResponse(w, r)
 		default:
 			a.serverErrorResponse(w, r, err)
@@ -104,7 +229,7 @@ func (a *applicationDependences) createPasswordResetHandler(w http.ResponseWrite
 		return
 	}
 
-	token, err := a.tokenModel.New(user.ID, 30*time.Minute, data.ScopePasswordReset)
+	err = a.sendPasswordResetToken(user)
 	if err != nil {
 		a.serverErrorResponse(w, r, err)
 		return
@@ -114,22 +239,410 @@ func (a *applicationDependences) createPasswordResetHandler(w http.ResponseWrite
 		"message": "temporary token has been sent to your email",
 	}
 
+	err = a.writeJSON(w, http.StatusCreated, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return //just in case to terminate function
+	}
+
+}
+
+// create a password reset token for the user and email it to them
+func (a *applicationDependences) sendPasswordResetToken(user *data.User) error {
+	token, err := a.tokenModel.New(user.ID, 30*time.Minute, data.ScopePasswordReset)
+	if err != nil {
+		return err
+	}
+
 	a.background(func() {
 		data := map[string]any{
 			"passwordResetToken": token.PlainText,
 			"userID":             user.ID,
 			"tokenExpiryTime":    token.Expiry,
 		}
-		err = a.mailer.Send(user.Email, "user_reset_password.tmpl", data)
+		err := a.mailer.Send(user.Email, "user_reset_password.tmpl", data)
 		if err != nil {
 			a.logger.Error(err.Error())
 		}
 	})
+	return nil
+}
+
+// log out: revoke the authentication token presented with this request
+func (a *applicationDependences) deleteAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	//signed tokens cannot be revoked, but the refresh tokens of their login can
+	if a.tokenVerifier != nil {
+		token, ok := a.readAuthorizationToken(r)
+		if ok && jwt.LooksSigned(token) {
+			claims, err := a.tokenVerifier.Verify(token)
+			if err != nil {
+				a.invalidAuthenticationTokenResponse(w, r)
+				return
+			}
+			err = a.tokenModel.DeleteFamily(claims.Family)
+			if err != nil {
+				a.serverErrorResponse(w, r, err)
+				return
+			}
+
+			err = a.writeJSON(w, http.StatusOK, envelope{"message": "you have been logged out"}, nil)
+			if err != nil {
+				a.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+	}
+
+	token, ok := a.readBearerToken(r)
+	if !ok {
+		a.invalidAuthenticationTokenResponse(w, r)
+		return
+	}
+
+	err := a.tokenModel.DeleteByPlaintext(data.ScopeAuthentication, token)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.invalidAuthenticationTokenResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	//the whole login family is revoked, so drop every cached token of the user rather than only this one
+	a.authCache.DeleteUser(a.contextGetUser(r).ID)
+
+	data := envelope{
+		"message": "you have been logged out",
+	}
+
+	err = a.writeJSON(w, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// log out everywhere: revoke every authentication token of the user
+func (a *applicationDependences) deleteAllAuthenticationTokensHandler(w http.ResponseWriter, r *http.Request) {
+	user := a.contextGetUser(r)
+
+	err := a.tokenModel.DeleteAllForUser(data.ScopeAuthentication, user.ID)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+	//without this the refresh tokens could be used to log straight back in
+	err = a.tokenModel.DeleteAllForUser(data.ScopeRefresh, user.ID)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+	a.authCache.DeleteUser(user.ID)
+
+	data := envelope{
+		"message": "you have been logged out of all sessions",
+	}
+
+	err = a.writeJSON(w, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// list the active sessions (authentication tokens) of the user
+func (a *applicationDependences) listAuthenticationTokensHandler(w http.ResponseWriter, r *http.Request) {
+	user := a.contextGetUser(r)
+
+	//signed tokens are not stored, so in signed mode every login is listed through its refresh token
+	if a.tokenVerifier != nil {
+		token, ok := a.readAuthorizationToken(r)
+		if ok && jwt.LooksSigned(token) {
+			claims, err := a.tokenVerifier.Verify(token)
+			if err != nil {
+				a.invalidAuthenticationTokenResponse(w, r)
+				return
+			}
+			a.writeSessions(w, r, data.ScopeRefresh, user.ID, "", claims.Family)
+			return
+		}
+	}
+
+	//authenticate already checked the token so this always succeeds
+	token, _ := a.readBearerToken(r)
+	a.writeSessions(w, r, data.ScopeAuthentication, user.ID, token, "")
+}
+
+// respond with the sessions of a user, see TokenModel.GetSessionsForUser
+func (a *applicationDependences) writeSessions(w http.ResponseWriter, r *http.Request, scope string, userID int64, currentToken, currentFamily string) {
+	sessions, err := a.tokenModel.GetSessionsForUser(scope, userID, currentToken, currentFamily)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	data := envelope{
+		"sessions": sessions,
+	}
+
+	err = a.writeJSON(w, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// create a short-lived authentication token and a refresh token in the given family
+func (a *applicationDependences) issueTokenPair(user *data.User, userAgent, family string) (*data.Token, *data.Token, error) {
+	token, err := a.newAuthenticationToken(user, userAgent, family)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	refreshToken, err := a.tokenModel.NewForClient(user.ID, a.config.auth.refreshTTL, data.ScopeRefresh, userAgent, family)
+	if err != nil {
+		return nil, nil, err
+	}
+	return token, refreshToken, nil
+}
+
+// an opaque database token, or a signed token carrying the user's permissions when signed mode is on
+func (a *applicationDependences) newAuthenticationToken(user *data.User, userAgent, family string) (*data.Token, error) {
+	if a.tokenSigner == nil {
+		return a.tokenModel.NewForClient(user.ID, a.config.auth.accessTTL, data.ScopeAuthentication, userAgent, family)
+	}
+
+	permissions, err := a.permisionsModel.GetAllForUser(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	claims := jwt.Claims{
+		Subject:     user.ID,
+		Activated:   user.Activated,
+		Permissions: permissions,
+		Family:      family,
+	}
+	plainText, expiry, err := a.tokenSigner.Sign(claims, a.config.auth.accessTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	return &data.Token{
+		PlainText: plainText,
+		UserID:    int(user.ID),
+		Expiry:    expiry,
+		Scope:     data.ScopeAuthentication,
+		Family:    family,
+	}, nil
+}
+
+// exchange a refresh token for a new authentication token, the refresh token is rotated on every use
+func (a *applicationDependences) refreshAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var incomingData struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	err := a.readJSON(w, r, &incomingData)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	data.ValidatetokenPlaintext(v, incomingData.RefreshToken)
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	refreshToken, err := a.tokenModel.GetByPlaintext(data.ScopeRefresh, incomingData.RefreshToken)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.invalidAuthenticationTokenResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	//an expired refresh token is refused before it is marked as used, so it never looks like reuse
+	if time.Now().After(refreshToken.Expiry) {
+		a.invalidAuthenticationTokenResponse(w, r)
+		return
+	}
+
+	/*a refresh token can only be used once. Seeing it again means it was
+	stolen (or the client is misbehaving) so we revoke every token that came
+	from the same login and make the user sign in again*/
+	err = a.tokenModel.MarkUsed(refreshToken)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrTokenReused):
+			a.logger.Warn("refresh token reuse detected, revoking token family", "user_id", refreshToken.UserID)
+			err = a.tokenModel.DeleteFamily(refreshToken.Family)
+			if err != nil {
+				a.serverErrorResponse(w, r, err)
+				return
+			}
+			a.authCache.DeleteUser(int64(refreshToken.UserID))
+			a.invalidAuthenticationTokenResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	//signed tokens carry the activation state and permissions so we need the current user
+	user, err := a.userModel.GetByID(int64(refreshToken.UserID))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.invalidAuthenticationTokenResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	//the access token issued with the rotated refresh token is replaced, not kept alongside the new one
+	err = a.tokenModel.DeleteFamilyScope(data.ScopeAuthentication, refreshToken.Family)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+	a.authCache.DeleteUser(user.ID)
+
+	token, newRefreshToken, err := a.issueTokenPair(user, r.UserAgent(), refreshToken.Family)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	data := envelope{
+		"authentication_token": token,
+		"refresh_token":        newRefreshToken,
+	}
 
 	err = a.writeJSON(w, http.StatusCreated, data, nil)
 	if err != nil {
 		a.serverErrorResponse(w, r, err)
-		return //just in case to terminate function
 	}
+}
 
+// send a fresh activation token to a user who never received or lost the welcome email
+func (a *applicationDependences) createActivationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var incomingData struct {
+		Email string `json:"email"`
+	}
+
+	err := a.readJSON(w, r, &incomingData)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	data.ValidateEmail(v, incomingData.Email)
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	//stop anyone from flooding an inbox with activation emails
+	if !a.activationMails.Allow(incomingData.Email) {
+		a.rateLimitExceededResponse(w, r)
+		return
+	}
+
+	//like password resets, unknown and activated addresses get the same answer
+	if a.config.auth.hideAccounts {
+		email := incomingData.Email
+		a.background(func() {
+			user, err := a.userModel.GetByEmail(email)
+			if err != nil {
+				if !errors.Is(err, data.ErrRecordNotFound) {
+					a.logger.Error(err.Error())
+				}
+				return
+			}
+			if user.Activated {
+				return
+			}
+
+			err = a.sendActivationToken(user)
+			if err != nil {
+				a.logger.Error(err.Error())
+			}
+		})
+
+		data := envelope{
+			"message": "if an account with this email address is waiting to be activated, an email will be sent to it containing activation instructions",
+		}
+
+		err = a.writeJSON(w, http.StatusAccepted, data, nil)
+		if err != nil {
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	user, err := a.userModel.GetByEmail(incomingData.Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("email", "no matching email address found")
+			a.failedValidationResponse(w, r, v.Errors)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if user.Activated {
+		v.AddError("email", "user has already been activated")
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = a.sendActivationToken(user)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	data := envelope{
+		"message": "an email will be sent to you containing activation instructions",
+	}
+
+	err = a.writeJSON(w, http.StatusAccepted, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// replace the activation tokens of the user with a new one and email it to them
+func (a *applicationDependences) sendActivationToken(user *data.User) error {
+	//only the newest activation token should work
+	err := a.tokenModel.DeleteAllForUser(data.ScopeActivation, user.ID)
+	if err != nil {
+		return err
+	}
+
+	token, err := a.tokenModel.New(user.ID, 3*24*time.Hour, data.ScopeActivation)
+	if err != nil {
+		return err
+	}
+
+	a.background(func() {
+		data := map[string]any{
+			"activationToken": token.PlainText,
+			"userID":          user.ID,
+		}
+		err := a.mailer.Send(user.Email, "user_welcome.tmpl", data)
+		if err != nil {
+			a.logger.Error(err.Error())
+		}
+	})
+	return nil
 }
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/abner-tech/Test3-Api.git/internal/data"
+	"github.com/abner-tech/Test3-Api.git/internal/totp"
+	"github.com/abner-tech/Test3-Api.git/internal/validator"
+)
+
+// how long the second step of a login may take
+const twoFactorChallengeTTL = 5 * time.Minute
+
+/*
+check the second factor of a user, either a code from their authenticator
+app or one of their recovery codes. Both can only be used once
+*/
+func (a *applicationDependences) checkSecondFactor(userID int64, code, recoveryCode string) (bool, error) {
+	if recoveryCode != "" {
+		err := a.twoFactorModel.UseRecoveryCode(userID, recoveryCode)
+		if err != nil {
+			if errors.Is(err, data.ErrRecordNotFound) {
+				return false, nil
+			}
+			return false, err
+		}
+		return true, nil
+	}
+
+	twoFactor, err := a.twoFactorModel.Get(userID)
+	if err != nil {
+		return false, err
+	}
+	if !twoFactor.Enabled {
+		return false, nil
+	}
+
+	step, ok := totp.Validate(twoFactor.Secret, code, time.Now())
+	if !ok {
+		return false, nil
+	}
+
+	err = a.twoFactorModel.UseStep(userID, step)
+	if err != nil {
+		if errors.Is(err, data.ErrCodeReused) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// start enrolling the authenticated user, returns the secret to add to an authenticator app
+func (a *applicationDependences) enableTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	//the email labels the account in the authenticator app, signed tokens do not carry it
+	user, err := a.userModel.GetByID(a.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = a.twoFactorModel.SetPendingSecret(user.ID, secret)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrTwoFactorEnabled):
+			v := validator.New()
+			v.AddError("two_factor", "two-factor authentication is already enabled")
+			a.failedValidationResponse(w, r, v.Errors)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	data := envelope{
+		"secret":      secret,
+		"otpauth_uri": totp.URI(a.config.auth.totpIssuer, user.Email, secret),
+		"message":     "add the secret to your authenticator app and confirm it with a code to turn on two-factor authentication",
+	}
+
+	err = a.writeJSON(w, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// confirm enrollment with a first code, the recovery codes are only ever shown here
+func (a *applicationDependences) confirmTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	user := a.contextGetUser(r)
+
+	var incomingData struct {
+		Code string `json:"code"`
+	}
+
+	err := a.readJSON(w, r, &incomingData)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(incomingData.Code != "", "code", "must be provided")
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	twoFactor, err := a.twoFactorModel.Get(user.ID)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+	if twoFactor.Enabled || twoFactor.Secret == "" {
+		v.AddError("two_factor", "there is no two-factor enrollment to confirm")
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	step, ok := totp.Validate(twoFactor.Secret, incomingData.Code, time.Now())
+	if !ok {
+		v.AddError("code", "invalid code")
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	recoveryCodes, err := data.GenerateRecoveryCodes(data.RecoveryCodeCount)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = a.twoFactorModel.Enable(user.ID, step, recoveryCodes)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrTwoFactorEnabled):
+			v.AddError("two_factor", "two-factor authentication is already enabled")
+			a.failedValidationResponse(w, r, v.Errors)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	a.authCache.DeleteUser(user.ID)
+
+	data := envelope{
+		"message":        "two-factor authentication is on, keep the recovery codes somewhere safe",
+		"recovery_codes": recoveryCodes,
+	}
+
+	err = a.writeJSON(w, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// turn two-factor authentication off, needs the password and a code
+func (a *applicationDependences) disableTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	var incomingData struct {
+		Password     string `json:"password"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+
+	err := a.readJSON(w, r, &incomingData)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(incomingData.Password != "", "password", "must be provided")
+	v.Check(incomingData.Code != "" || incomingData.RecoveryCode != "", "code", "a code or a recovery code must be provided")
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := a.userModel.GetByID(a.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !user.TwoFactor {
+		v.AddError("two_factor", "two-factor authentication is not enabled")
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	match, err := user.Password.Matches(incomingData.Password)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+	if !match {
+		v.AddError("password", "is incorrect")
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	ok, err := a.checkSecondFactor(user.ID, incomingData.Code, incomingData.RecoveryCode)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+	if !ok {
+		v.AddError("code", "invalid code")
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = a.twoFactorModel.Disable(user.ID)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+	a.authCache.DeleteUser(user.ID)
+
+	data := envelope{
+		"message": "two-factor authentication is off",
+	}
+
+	err = a.writeJSON(w, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// second step of a login: exchange the challenge token and a code for real tokens
+func (a *applicationDependences) verifyTwoFactorLoginHandler(w http.ResponseWriter, r *http.Request) {
+	var incomingData struct {
+		ChallengeToken string `json:"challenge_token"`
+		Code           string `json:"code"`
+		RecoveryCode   string `json:"recovery_code"`
+	}
+
+	err := a.readJSON(w, r, &incomingData)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	data.ValidatetokenPlaintext(v, incomingData.ChallengeToken)
+	v.Check(incomingData.Code != "" || incomingData.RecoveryCode != "", "code", "a code or a recovery code must be provided")
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := a.userModel.GetForToken(data.ScopeTwoFactorChallenge, incomingData.ChallengeToken)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.invalidCredentialResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	//wrong codes count towards the same lockout as wrong passwords
+	if a.loginLocked(w, r, user) {
+		return
+	}
+
+	ok, err := a.checkSecondFactor(user.ID, incomingData.Code, incomingData.RecoveryCode)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+	if !ok {
+		err = a.recordFailedLogin(user)
+		if err != nil {
+			a.serverErrorResponse(w, r, err)
+			return
+		}
+		a.invalidCredentialResponse(w, r)
+		return
+	}
+
+	//a challenge token is good for one login only
+	err = a.tokenModel.DeleteAllForUser(data.ScopeTwoFactorChallenge, user.ID)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	a.completeLogin(w, r, user)
+}
@@ -1,11 +1,17 @@
 package main
 
 import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/abner-tech/Test3-Api.git/internal/data"
+	"github.com/abner-tech/Test3-Api.git/internal/jwt"
 	"github.com/abner-tech/Test3-Api.git/internal/validator"
 )
 
@@ -29,7 +35,7 @@ func (a *applicationDependences) registerUserHandler(w http.ResponseWriter, r *h
 	}
 
 	//hashing password and storing with the cleartect version
-	err = user.Password.Set(incomingData.Password)
+	err = a.userModel.SetPassword(user, incomingData.Password)
 	if err != nil {
 		a.serverErrorResponse(w, r, err)
 		return
@@ -38,6 +44,7 @@ func (a *applicationDependences) registerUserHandler(w http.ResponseWriter, r *h
 	//user validation
 	v := validator.New()
 	data.ValidateUser(v, user)
+	a.passwordPolicy.Validate(v, incomingData.Password, user.Username, user.Email)
 	if !v.IsEmpty() {
 		a.failedValidationResponse(w, r, v.Errors)
 		return
@@ -46,6 +53,17 @@ func (a *applicationDependences) registerUserHandler(w http.ResponseWriter, r *h
 	err = a.userModel.Insert(user)
 	if err != nil {
 		switch {
+		case errors.Is(err, data.ErrDuplicateEmail) && a.config.auth.hideAccounts:
+			//tell the owner of the address instead of the caller, at most once per activation interval
+			if a.activationMails.Allow(user.Email) {
+				a.background(func() {
+					err := a.mailer.Send(user.Email, "user_signup_attempt.tmpl", map[string]any{})
+					if err != nil {
+						a.logger.Error(err.Error())
+					}
+				})
+			}
+			a.registrationAcceptedResponse(w, r)
 		case errors.Is(err, data.ErrDuplicateEmail):
 			v.AddError("email", "a user with this email address already exists")
 			a.failedValidationResponse(w, r, v.Errors)
@@ -55,8 +73,8 @@ func (a *applicationDependences) registerUserHandler(w http.ResponseWriter, r *h
 		return
 	}
 
-	//read permissions for new users, later we addd the write when needed
-	err = a.permisionsModel.AddForUser(user.ID, "reviews:read")
+	//new users start out as readers, later we assign more roles when needed
+	err = a.roleModel.AssignToUser(user.ID, data.RoleReader)
 	if err != nil {
 		a.serverErrorResponse(w, r, err)
 		return
@@ -89,6 +107,12 @@ func (a *applicationDependences) registerUserHandler(w http.ResponseWriter, r *h
 
 	})
 
+	//the same answer as for an address that already has an account
+	if a.config.auth.hideAccounts {
+		a.registrationAcceptedResponse(w, r)
+		return
+	}
+
 	//status code 201 resource created
 	err = a.writeJSON(w, http.StatusCreated, data, nil)
 	if err != nil {
@@ -97,6 +121,18 @@ func (a *applicationDependences) registerUserHandler(w http.ResponseWriter, r *h
 	}
 }
 
+// what registration answers when accounts are hidden, whether or not the email was taken
+func (a *applicationDependences) registrationAcceptedResponse(w http.ResponseWriter, r *http.Request) {
+	data := envelope{
+		"message": "an email has been sent to the address with instructions to finish signing up",
+	}
+
+	err := a.writeJSON(w, http.StatusAccepted, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
 func (a *applicationDependences) activateUserHandler(w http.ResponseWriter, r *http.Request) {
 	//get the body from the request and store in temporary struct
 
@@ -150,6 +186,8 @@ func (a *applicationDependences) activateUserHandler(w http.ResponseWriter, r *h
 		a.serverErrorResponse(w, r, err)
 		return
 	}
+	//cached authentications still see the user as not activated
+	a.authCache.DeleteUser(user.ID)
 
 	//send response
 	data := envelope{
@@ -183,9 +221,15 @@ func (a *applicationDependences) listUserProfileHandler(w http.ResponseWriter, r
 		return
 	}
 
+	//only the user themselves gets to see their email and preferences
+	var profile any = user.Public()
+	if a.contextGetUser(r).ID == user.ID {
+		profile = user
+	}
+
 	//display the user information
 	data := envelope{
-		"user": user,
+		"user": profile,
 	}
 
 	err = a.writeJSON(w, http.StatusOK, data, nil)
@@ -195,6 +239,400 @@ func (a *applicationDependences) listUserProfileHandler(w http.ResponseWriter, r
 	}
 }
 
+// the full profile of the authenticated user
+func (a *applicationDependences) showCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := a.userModel.GetByID(a.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	//the version lets clients send it back on PATCH to detect lost updates
+	data := envelope{
+		"user":    user,
+		"version": user.Version,
+	}
+
+	err = a.writeJSON(w, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// partially update the profile of the authenticated user
+func (a *applicationDependences) updateCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := a.userModel.GetByID(a.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	//pointers so we can tell a missing field from an empty one
+	var incomingData struct {
+		Username    *string          `json:"username"`
+		Bio         *string          `json:"bio"`
+		AvatarURL   *string          `json:"avatar_url"`
+		Preferences *json.RawMessage `json:"preferences"`
+		Version     *int             `json:"version"`
+	}
+
+	err = a.readJSON(w, r, &incomingData)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	//the client edited an older copy of the profile
+	if incomingData.Version != nil && *incomingData.Version != user.Version {
+		a.editConflictResponse(w, r)
+		return
+	}
+
+	if incomingData.Username != nil {
+		user.Username = *incomingData.Username
+	}
+	if incomingData.Bio != nil {
+		user.Bio = *incomingData.Bio
+	}
+	if incomingData.AvatarURL != nil {
+		user.AvatarURL = *incomingData.AvatarURL
+	}
+	if incomingData.Preferences != nil {
+		user.Preferences = *incomingData.Preferences
+	}
+
+	v := validator.New()
+	data.ValidateProfile(v, user)
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = a.userModel.Update(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConfilct):
+			a.editConflictResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	//cached authentications hold the old profile
+	a.authCache.DeleteUser(user.ID)
+
+	data := envelope{
+		"user":    user,
+		"version": user.Version,
+	}
+
+	err = a.writeJSON(w, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// change the password of the authenticated user, other logins are signed out
+func (a *applicationDependences) changePasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var incomingData struct {
+		CurrentPassword string `json:"currentPassword"`
+		NewPassword     string `json:"newPassword"`
+	}
+
+	err := a.readJSON(w, r, &incomingData)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(incomingData.CurrentPassword != "", "currentPassword", "must be provided")
+	data.ValidatePassword(v, incomingData.NewPassword)
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	//the user in the context does not carry the password hash when authenticated by a signed token
+	user, err := a.userModel.GetByID(a.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	match, err := user.Password.Matches(incomingData.CurrentPassword)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+	if !match {
+		v.AddError("currentPassword", "is incorrect")
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	a.passwordPolicy.Validate(v, incomingData.NewPassword, user.Username, user.Email)
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = a.userModel.SetPassword(user, incomingData.NewPassword)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = a.userModel.Update(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConfilct):
+			a.editConflictResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	//keep the login used for this request, sign out everywhere else
+	token, _ := a.readAuthorizationToken(r)
+	family := ""
+	if a.tokenVerifier != nil && jwt.LooksSigned(token) {
+		claims, err := a.tokenVerifier.Verify(token)
+		if err == nil {
+			family = claims.Family
+		}
+	} else {
+		current, err := a.tokenModel.GetByPlaintext(data.ScopeAuthentication, token)
+		if err == nil {
+			family = current.Family
+		}
+	}
+
+	err = a.tokenModel.DeleteOtherSessions(user.ID, token, family)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+	a.authCache.DeleteUser(user.ID)
+
+	//let the owner know, in case it was not them
+	a.background(func() {
+		data := map[string]any{
+			"userID":   user.ID,
+			"username": user.Username,
+		}
+		err := a.mailer.Send(user.Email, "user_password_changed.tmpl", data)
+		if err != nil {
+			a.logger.Error(err.Error())
+		}
+	})
+
+	data := envelope{
+		"message": "your password was changed, your other sessions have been signed out",
+	}
+
+	err = a.writeJSON(w, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+/*
+delete the account of the authenticated user. The account is only scheduled
+for deletion: every session is signed out right away, and the account with
+its reading lists and reviews is removed by the janitor once the grace period
+is over unless the user logs in again before that
+*/
+func (a *applicationDependences) deleteCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+	var incomingData struct {
+		Password string `json:"password"`
+	}
+
+	err := a.readJSON(w, r, &incomingData)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(incomingData.Password != "", "password", "must be provided")
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := a.userModel.GetByID(a.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	match, err := user.Password.Matches(incomingData.Password)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+	if !match {
+		v.AddError("password", "is incorrect")
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	deleteAt := time.Now().Add(a.config.account.deletionGrace)
+	err = a.userModel.ScheduleDeletion(user.ID, deleteAt)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	//sign out everywhere, api keys are refused while the deletion is scheduled
+	for _, scope := range []string{data.ScopeAuthentication, data.ScopeRefresh} {
+		err = a.tokenModel.DeleteAllForUser(scope, user.ID)
+		if err != nil {
+			a.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+	a.authCache.DeleteUser(user.ID)
+
+	a.background(func() {
+		data := map[string]any{
+			"username": user.Username,
+			"deleteAt": deleteAt.Format(time.RFC1123),
+		}
+		err := a.mailer.Send(user.Email, "user_account_deletion.tmpl", data)
+		if err != nil {
+			a.logger.Error(err.Error())
+		}
+	})
+
+	data := envelope{
+		"message":               "your account will be deleted, log in before then to keep it",
+		"deletion_scheduled_at": deleteAt,
+	}
+
+	err = a.writeJSON(w, http.StatusAccepted, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// everything we hold about the authenticated user, as json or as a zip of json files (?format=zip)
+func (a *applicationDependences) exportCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+	format := a.getSingleQueryParameter(r.URL.Query(), "format", "json")
+
+	v := validator.New()
+	v.Check(validator.PermittedValue(format, "json", "zip"), "format", "must be json or zip")
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := a.userModel.GetByID(a.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	lists, err := a.readingListModel.GetByUserID(user.ID)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+	for _, list := range lists {
+		list.Books, err = a.readingListModel.GetBooksInList(list.ID)
+		if err != nil {
+			a.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	reviews, err := a.reviewModel.GetAllByUserID(user.ID, a.reviewViewer(r))
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	//one entry per file in the zip archive
+	export := envelope{
+		"profile":       user,
+		"reading_lists": lists,
+		"reviews":       reviews,
+	}
+
+	headers := make(http.Header)
+	filename := fmt.Sprintf("user-%d-export", user.ID)
+
+	if format == "json" {
+		headers.Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.json"`, filename))
+		err = a.writeJSON(w, http.StatusOK, envelope{"export": export, "exported_at": time.Now()}, headers)
+		if err != nil {
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	//build the archive in memory so a failure can still become an error response
+	var archive bytes.Buffer
+	zipWriter := zip.NewWriter(&archive)
+	for _, name := range []string{"profile", "reading_lists", "reviews"} {
+		contents, err := json.MarshalIndent(export[name], "", "\t")
+		if err != nil {
+			a.serverErrorResponse(w, r, err)
+			return
+		}
+
+		file, err := zipWriter.Create(name + ".json")
+		if err != nil {
+			a.serverErrorResponse(w, r, err)
+			return
+		}
+
+		_, err = file.Write(contents)
+		if err != nil {
+			a.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	err = zipWriter.Close()
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.zip"`, filename))
+	w.WriteHeader(http.StatusOK)
+	w.Write(archive.Bytes())
+}
+
 func (a *applicationDependences) userPasswordReset(w http.ResponseWriter, r *http.Request) {
 
 	var incomingData struct {
@@ -223,6 +661,7 @@ func (a *applicationDependences) userPasswordReset(w http.ResponseWriter, r *htt
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
 			v.AddError("token", "invalid or expired token")
+			a.failedValidationResponse(w, r, v.Errors)
 		default:
 			a.serverErrorResponse(w, r, err)
 		}
@@ -237,8 +676,14 @@ func (a *applicationDependences) userPasswordReset(w http.ResponseWriter, r *htt
 		return
 	}
 
+	a.passwordPolicy.Validate(v, incomingData.NewPassword, user.Username, user.Email)
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
 	//hashing password and storing with the current user version
-	err = user.Password.Set(incomingData.NewPassword)
+	err = a.userModel.SetPassword(user, incomingData.NewPassword)
 	if err != nil {
 		a.serverErrorResponse(w, r, err)
 		return
@@ -263,6 +708,13 @@ func (a *applicationDependences) userPasswordReset(w http.ResponseWriter, r *htt
 		a.serverErrorResponse(w, r, err)
 		return
 	}
+	//a new password ends any lockout
+	err = a.userModel.ResetFailedLogins(user.ID)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+	a.authCache.DeleteUser(user.ID)
 
 	data := envelope{
 		"message": "password changed sucessfully",
@@ -275,3 +727,161 @@ func (a *applicationDependences) userPasswordReset(w http.ResponseWriter, r *htt
 		return
 	}
 }
+
+// start changing the email address of the logged in user, nothing changes until the new address is confirmed
+func (a *applicationDependences) requestEmailChangeHandler(w http.ResponseWriter, r *http.Request) {
+	var incomingData struct {
+		Email string `json:"email"`
+	}
+
+	err := a.readJSON(w, r, &incomingData)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	data.ValidateEmail(v, incomingData.Email)
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	//the context may only hold the user id (signed tokens) so load the full record
+	user, err := a.userModel.GetByID(a.contextGetUser(r).ID)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if strings.EqualFold(user.Email, incomingData.Email) {
+		v.AddError("email", "must be different from your current email address")
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	//the address must not belong to someone else
+	_, err = a.userModel.GetByEmail(incomingData.Email)
+	switch {
+	case err == nil:
+		v.AddError("email", "a user with this email address already exists")
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	case !errors.Is(err, data.ErrRecordNotFound):
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = a.userModel.SetPendingEmail(user.ID, incomingData.Email)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	//only the newest request can be confirmed
+	err = a.tokenModel.DeleteAllForUser(data.ScopeEmailChange, user.ID)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	token, err := a.tokenModel.New(user.ID, 24*time.Hour, data.ScopeEmailChange)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	a.background(func() {
+		data := map[string]any{
+			"emailChangeToken": token.PlainText,
+			"userID":           user.ID,
+			"tokenExpiryTime":  token.Expiry,
+		}
+		err := a.mailer.Send(incomingData.Email, "user_email_change.tmpl", data)
+		if err != nil {
+			a.logger.Error(err.Error())
+		}
+
+		//let the current address know in case this was not the owner
+		notice := map[string]any{
+			"userID":   user.ID,
+			"newEmail": incomingData.Email,
+		}
+		err = a.mailer.Send(user.Email, "user_email_change_notice.tmpl", notice)
+		if err != nil {
+			a.logger.Error(err.Error())
+		}
+	})
+
+	data := envelope{
+		"message": "a confirmation email has been sent to the new address",
+	}
+
+	err = a.writeJSON(w, http.StatusAccepted, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// finish changing the email address using the token sent to the new address
+func (a *applicationDependences) confirmEmailChangeHandler(w http.ResponseWriter, r *http.Request) {
+	var incomingData struct {
+		TokenPlainText string `json:"token"`
+	}
+
+	err := a.readJSON(w, r, &incomingData)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	data.ValidatetokenPlaintext(v, incomingData.TokenPlainText)
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := a.userModel.GetForToken(data.ScopeEmailChange, incomingData.TokenPlainText)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired email change token")
+			a.failedValidationResponse(w, r, v.Errors)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = a.userModel.ConfirmPendingEmail(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateEmail):
+			v.AddError("email", "a user with this email address already exists")
+			a.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired email change token")
+			a.failedValidationResponse(w, r, v.Errors)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = a.tokenModel.DeleteAllForUser(data.ScopeEmailChange, user.ID)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+	a.authCache.DeleteUser(user.ID)
+
+	data := envelope{
+		"user": user,
+	}
+
+	err = a.writeJSON(w, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,208 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/abner-tech/Test3-Api.git/internal/validator"
+	"github.com/lib/pq"
+)
+
+// every key starts with this so they are easy to recognise, e.g. by secret scanners
+const APIKeyPrefix = "bkm_"
+
+// a personal api key, the plaintext is only filled in right after it is created
+type APIKey struct {
+	ID          int64       `json:"id"`
+	UserID      int64       `json:"-"`
+	Name        string      `json:"name"`
+	Prefix      string      `json:"prefix"`
+	PlainText   string      `json:"key,omitempty"`
+	Permissions Permissions `json:"permissions"`
+	CreatedAt   time.Time   `json:"created_at"`
+	LastUsedAt  *time.Time  `json:"last_used_at"`
+	Expiry      *time.Time  `json:"expiry"`
+}
+
+// database access
+type APIKeyModel struct {
+	DB *sql.DB
+}
+
+func ValidateAPIKey(v *validator.Validator, key *APIKey) {
+	v.Check(key.Name != "", "name", "must be provided")
+	v.Check(len(key.Name) <= 100, "name", "must not be more than 100 bytes long")
+	v.Check(len(key.Permissions) > 0, "permissions", "must contain at least one permission code")
+}
+
+// create and store a new key for the user, ttl zero means it never expires
+func (k *APIKeyModel) New(userID int64, name string, permissions Permissions, ttl time.Duration) (*APIKey, error) {
+	randomBytes := make([]byte, 20)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	plainText := APIKeyPrefix + strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes))
+	key := &APIKey{
+		UserID:      userID,
+		Name:        name,
+		Prefix:      plainText[:len(APIKeyPrefix)+6],
+		PlainText:   plainText,
+		Permissions: permissions,
+	}
+	if ttl > 0 {
+		expiry := time.Now().Add(ttl)
+		key.Expiry = &expiry
+	}
+
+	hash := sha256.Sum256([]byte(plainText))
+
+	query := `
+	INSERT INTO api_keys (user_id, name, prefix, hash, permissions, expiry)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	RETURNING id, created_at
+	`
+
+	args := []any{key.UserID, key.Name, key.Prefix, hash[:], pq.Array([]string(key.Permissions)), key.Expiry}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err = k.DB.QueryRowContext(ctx, query, args...).Scan(&key.ID, &key.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// the keys of a user, newest first
+func (k *APIKeyModel) GetAllForUser(userID int64) ([]*APIKey, error) {
+	query := `
+	SELECT id, user_id, name, prefix, permissions, created_at, last_used_at, expiry
+	FROM api_keys
+	WHERE user_id = $1
+	ORDER BY created_at DESC, id DESC
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := k.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []*APIKey{}
+	for rows.Next() {
+		var key APIKey
+		err := rows.Scan(
+			&key.ID,
+			&key.UserID,
+			&key.Name,
+			&key.Prefix,
+			pq.Array((*[]string)(&key.Permissions)),
+			&key.CreatedAt,
+			&key.LastUsedAt,
+			&key.Expiry,
+		)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, &key)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+/*
+look up the user behind a key the client presented and record that the key
+was used, in the same round-trip. Expired keys, and the keys of accounts
+scheduled for deletion, are treated as unknown
+*/
+func (k *APIKeyModel) GetForKey(plainText string) (*User, *APIKey, error) {
+	hash := sha256.Sum256([]byte(plainText))
+
+	query := `
+	WITH used_key AS (
+		UPDATE api_keys
+		SET last_used_at = NOW()
+		WHERE hash = $1
+		AND (expiry IS NULL OR expiry > $2)
+		AND user_id IN (SELECT id FROM users WHERE deletion_scheduled_at IS NULL)
+		RETURNING id, user_id, name, prefix, permissions, created_at, last_used_at, expiry
+	)
+	SELECT used_key.id, used_key.name, used_key.prefix, used_key.permissions, used_key.created_at,
+	used_key.last_used_at, used_key.expiry,
+	users.id, users.created_at, users.username, users.email, users.activated, users.version
+	FROM used_key
+	INNER JOIN users
+	ON users.id = used_key.user_id
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var key APIKey
+	var user User
+	err := k.DB.QueryRowContext(ctx, query, hash[:], time.Now()).Scan(
+		&key.ID,
+		&key.Name,
+		&key.Prefix,
+		pq.Array((*[]string)(&key.Permissions)),
+		&key.CreatedAt,
+		&key.LastUsedAt,
+		&key.Expiry,
+		&user.ID,
+		&user.Created_At,
+		&user.Username,
+		&user.Email,
+		&user.Activated,
+		&user.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, nil, ErrRecordNotFound
+		default:
+			return nil, nil, err
+		}
+	}
+	key.UserID = user.ID
+	return &user, &key, nil
+}
+
+// revoke a key, only its owner can do that
+func (k *APIKeyModel) Delete(id, userID int64) error {
+	query := `
+	DELETE FROM api_keys
+	WHERE id = $1 AND user_id = $2
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := k.DB.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
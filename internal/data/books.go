@@ -26,6 +26,8 @@ type Book struct {
 	Genre            []string  `json:"genre"`
 	Description      string    `json:"description"`
 	Average_Rating   float32   `json:"average_rating"`
+	Rating_Count     int32     `json:"rating_count"`
+	Rating_Histogram []int64   `json:"rating_histogram"` //number of 1 to 5 star reviews
 	Version          int16     `json:"version"`
 }
 
@@ -70,7 +72,7 @@ func (b *BookModel) Insert(book *Book) error {
 	query := `
 	INSERT INTO books (title, authors, isbn, publication_date, genre, description)
 	VALUES ($1, $2, $3, $4, $5, $6)
-	RETURNING id, average_rating
+	RETURNING id, average_rating, rating_count, rating_histogram
 	`
 
 	args := []any{book.Title, pq.Array(book.Authors), book.ISBN, book.Publication_Date, pq.Array(book.Genre), book.Description}
@@ -78,13 +80,13 @@ func (b *BookModel) Insert(book *Book) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	return b.DB.QueryRowContext(ctx, query, args...).Scan(&book.ID, &book.Average_Rating)
+	return b.DB.QueryRowContext(ctx, query, args...).Scan(&book.ID, &book.Average_Rating, &book.Rating_Count, pq.Array(&book.Rating_Histogram))
 }
 
 // list all books
 func (b *BookModel) GetAll(filters Fileters) ([]*Book, Metadata, error) {
 	query := fmt.Sprintf(`
-	SELECT COUNT(*) OVER(), id, title, authors, isbn, publication_date, genre, description, average_rating , version
+	SELECT COUNT(*) OVER(), id, title, authors, isbn, publication_date, genre, description, average_rating, rating_count, rating_histogram, version
 	FROM books
 	ORDER BY %s %s, id ASC
 	LIMIT $1 OFFSET $2
@@ -119,6 +121,8 @@ func (b *BookModel) GetAll(filters Fileters) ([]*Book, Metadata, error) {
 			pq.Array(&book.Genre),
 			&book.Description,
 			&book.Average_Rating,
+			&book.Rating_Count,
+			pq.Array(&book.Rating_Histogram),
 			&book.Version,
 		)
 		if err != nil {
@@ -143,7 +147,7 @@ func (b *BookModel) GetByID(id int64) (*Book, error) {
 	}
 
 	query := `
-	SELECT title, authors, isbn, publication_date, genre, description, average_rating, version
+	SELECT title, authors, isbn, publication_date, genre, description, average_rating, rating_count, rating_histogram, version
 	FROM books
 	WHERE id = $1
 	`
@@ -160,6 +164,8 @@ func (b *BookModel) GetByID(id int64) (*Book, error) {
 		pq.Array(&book.Genre),
 		&book.Description,
 		&book.Average_Rating,
+		&book.Rating_Count,
+		pq.Array(&book.Rating_Histogram),
 		&book.Version,
 	)
 	//check if errors
@@ -234,7 +240,7 @@ func (b *BookModel) DeleteBook(id int64) error {
 func (b *BookModel) SearchGetAll(title, author, genre string, filters Fileters) ([]*Book, Metadata, error) {
 
 	query := fmt.Sprintf(`
-	SELECT COUNT(*) OVER(), id, title, authors, isbn, publication_date, genre, description, average_rating, version
+	SELECT COUNT(*) OVER(), id, title, authors, isbn, publication_date, genre, description, average_rating, rating_count, rating_histogram, version
 	FROM books
 	WHERE (to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1 = '')
 	  AND (to_tsvector('simple', array_to_string(authors, ' ')) @@ plainto_tsquery('simple', $2) OR $2 = '')
@@ -272,6 +278,8 @@ func (b *BookModel) SearchGetAll(title, author, genre string, filters Fileters)
 			pq.Array(&book.Genre),
 			&book.Description,
 			&book.Average_Rating,
+			&book.Rating_Count,
+			pq.Array(&book.Rating_Histogram),
 			&book.Version,
 		)
 		if err != nil {
@@ -306,3 +314,122 @@ func (b *BookModel) BookExists(id int64) error {
 
 	return b.DB.QueryRowContext(ctx, query, id).Scan(&ID)
 }
+
+// recompute the rating aggregate of a book from its visible reviews, returns false if it was already correct
+func updateBookRating(ctx context.Context, tx *sql.Tx, bookID int64) (bool, error) {
+	//star_histogram (migration 000023) decides which bucket a rating is counted in
+	query := `
+	UPDATE books
+	SET average_rating = agg.average, rating_count = agg.total, rating_histogram = agg.histogram
+	FROM (
+		SELECT COALESCE(ROUND(AVG(rating), 2), 0) AS average,
+			COUNT(rating)::integer AS total,
+			star_histogram(array_agg(rating::numeric)) AS histogram
+		FROM reviews
+		WHERE book_id = $1 AND status = 'visible'
+	) AS agg
+	WHERE books.id = $1
+	AND (books.average_rating, books.rating_count, books.rating_histogram)
+		IS DISTINCT FROM (agg.average, agg.total, agg.histogram)
+	`
+
+	result, err := tx.ExecContext(ctx, query, bookID)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rowsAffected > 0, nil
+}
+
+/*
+run fn in a transaction that holds the lock on the book row and then bring the
+rating aggregate of the book up to date. Taking the lock first makes concurrent
+review changes for the same book wait for each other, so the last one to commit
+always counts every review
+*/
+func withBookRating(db *sql.DB, bookID int64, fn func(ctx context.Context, tx *sql.Tx) error) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var id int64
+	err = tx.QueryRowContext(ctx, `SELECT id FROM books WHERE id = $1 FOR UPDATE`, bookID).Scan(&id)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return false, ErrRecordNotFound
+		default:
+			return false, err
+		}
+	}
+
+	if fn != nil {
+		err = fn(ctx, tx)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	changed, err := updateBookRating(ctx, tx, bookID)
+	if err != nil {
+		return false, err
+	}
+
+	return changed, tx.Commit()
+}
+
+// recompute the rating aggregate of every book, returns how many books were out of date
+func (b *BookModel) RepairRatings(ctx context.Context) (int64, error) {
+	queryCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	rows, err := b.DB.QueryContext(queryCtx, `SELECT id FROM books ORDER BY id`)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	ids := []int64{}
+	for rows.Next() {
+		var id int64
+		err := rows.Scan(&id)
+		if err != nil {
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	err = rows.Err()
+	if err != nil {
+		return 0, err
+	}
+
+	//one short transaction per book so reviews can keep changing meanwhile
+	var repaired int64
+	for _, id := range ids {
+		if ctx.Err() != nil {
+			return repaired, ctx.Err()
+		}
+
+		changed, err := withBookRating(b.DB, id, nil)
+		if err != nil {
+			//the book was deleted since we listed it
+			if errors.Is(err, ErrRecordNotFound) {
+				continue
+			}
+			return repaired, err
+		}
+		if changed {
+			repaired++
+		}
+	}
+	return repaired, nil
+}
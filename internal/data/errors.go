@@ -13,3 +13,17 @@ var ErrDuplicateEmail = errors.New("duplicate email encountered")
 var ErrEditConfilct = errors.New("edit confict")
 
 var ErrDuplicateBookInList = errors.New("duplicate book in reading list")
+
+var ErrDuplicateRoleName = errors.New("duplicate role name")
+
+var ErrUnknownPermission = errors.New("unknown permission code")
+
+var ErrTokenReused = errors.New("token has already been used")
+
+var ErrCodeReused = errors.New("one-time code has already been used")
+
+var ErrTwoFactorEnabled = errors.New("two-factor authentication already enabled")
+
+var ErrDuplicateReview = errors.New("book already reviewed by this user")
+
+var ErrDuplicateReport = errors.New("review already reported by this user")
@@ -17,11 +17,11 @@ type Fileters struct {
 }
 
 type Metadata struct {
-	CurrentPage  int `json:"current_page, omitempty"`
-	PageSize     int `json:"page_size, omitempty"`
-	FirstPage    int `json:"page_size, omitempty"`
-	LastPage     int `json:"last_page, omitempty"`
-	TotalRecords int `json:"total_records, omitempty"`
+	CurrentPage  int `json:"current_page,omitempty"`
+	PageSize     int `json:"page_size,omitempty"`
+	FirstPage    int `json:"first_page,omitempty"`
+	LastPage     int `json:"last_page,omitempty"`
+	TotalRecords int `json:"total_records,omitempty"`
 }
 
 //we validate page and Page size
@@ -0,0 +1,125 @@
+package data
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// a login that was sent to an OpenID Connect provider and is waiting for the callback
+type PendingLogin struct {
+	Provider string
+	Verifier string //PKCE code verifier
+	Nonce    string
+}
+
+// database access
+type IdentityModel struct {
+	DB *sql.DB
+}
+
+// the user linked to an account at a provider
+func (i *IdentityModel) GetUserID(provider, subject string) (int64, error) {
+	query := `
+	SELECT user_id
+	FROM user_identities
+	WHERE provider = $1 AND subject = $2
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var userID int64
+	err := i.DB.QueryRowContext(ctx, query, provider, subject).Scan(&userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, ErrRecordNotFound
+		default:
+			return 0, err
+		}
+	}
+	return userID, nil
+}
+
+// link an account at a provider to a user
+func (i *IdentityModel) Link(userID int64, provider, subject, email string) error {
+	query := `
+	INSERT INTO user_identities (user_id, provider, subject, email)
+	VALUES ($1, $2, $3, NULLIF($4, ''))
+	ON CONFLICT (provider, subject) DO NOTHING
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := i.DB.ExecContext(ctx, query, userID, provider, subject, email)
+	return err
+}
+
+// remember a login until the provider sends the user back with the same state
+func (i *IdentityModel) InsertPendingLogin(state string, login *PendingLogin, ttl time.Duration) error {
+	stateHash := sha256.Sum256([]byte(state))
+
+	query := `
+	INSERT INTO oidc_logins (state_hash, provider, verifier, nonce, expiry)
+	VALUES ($1, $2, $3, $4, $5)
+	`
+
+	args := []any{stateHash[:], login.Provider, login.Verifier, login.Nonce, time.Now().Add(ttl)}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := i.DB.ExecContext(ctx, query, args...)
+	return err
+}
+
+// fetch and delete a pending login, each state can only be used once
+func (i *IdentityModel) TakePendingLogin(provider, state string) (*PendingLogin, error) {
+	stateHash := sha256.Sum256([]byte(state))
+
+	query := `
+	DELETE FROM oidc_logins
+	WHERE state_hash = $1 AND provider = $2 AND expiry > $3
+	RETURNING provider, verifier, nonce
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var login PendingLogin
+	err := i.DB.QueryRowContext(ctx, query, stateHash[:], provider, time.Now()).Scan(
+		&login.Provider,
+		&login.Verifier,
+		&login.Nonce,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &login, nil
+}
+
+// forget logins that never came back from the provider
+func (i *IdentityModel) DeleteExpiredPendingLogins(now time.Time) (int64, error) {
+	query := `
+	DELETE FROM oidc_logins
+	WHERE expiry < $1
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	result, err := i.DB.ExecContext(ctx, query, now)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,342 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/abner-tech/Test3-Api.git/internal/validator"
+	"github.com/lib/pq"
+)
+
+// why a review can be reported
+var ReportReasons = []string{"spam", "offensive", "off_topic", "spoiler", "other"}
+
+// what a moderator can do with a review, and the state each action puts it in
+var ModerationActions = map[string]string{
+	"hide":    ReviewHidden,
+	"approve": ReviewVisible,
+	"hold":    ReviewPending,
+}
+
+// a user telling moderators something is wrong with a review
+type ReviewReport struct {
+	ID         int64      `json:"id"`
+	ReviewID   int64      `json:"review_id"`
+	UserID     int64      `json:"user_id"`
+	Reason     string     `json:"reason"`
+	Comment    string     `json:"comment"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ResolvedAt *time.Time `json:"resolved_at"`
+}
+
+// a moderation decision, the moderator is nil when the review was held automatically
+type ModerationAction struct {
+	ID          int64     `json:"id"`
+	ReviewID    int64     `json:"review_id"`
+	ModeratorID *int64    `json:"moderator_id"`
+	Action      string    `json:"action"`
+	Status      string    `json:"status"`
+	Note        string    `json:"note"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// a review waiting for a moderator along with its open reports
+type ModerationQueueEntry struct {
+	Review        *Review    `json:"review"`
+	OpenReports   int        `json:"open_reports"`
+	Reasons       []string   `json:"reasons"`
+	FirstReportAt *time.Time `json:"first_reported_at"`
+}
+
+// database access
+type ModerationModel struct {
+	DB *sql.DB
+}
+
+func ValidateReport(v *validator.Validator, report *ReviewReport) {
+	v.Check(validator.PermittedValue(report.Reason, ReportReasons...), "reason",
+		"must be one of: "+strings.Join(ReportReasons, ", "))
+	v.Check(len(report.Comment) <= 500, "comment", "must not be more than 500 bytes long")
+}
+
+func ValidateModerationAction(v *validator.Validator, action *ModerationAction) {
+	_, ok := ModerationActions[action.Action]
+	v.Check(ok, "action", "must be one of: hide, approve, hold")
+	v.Check(action.Note != "", "note", "must be provided")
+	v.Check(len(action.Note) <= 500, "note", "must not be more than 500 bytes long")
+}
+
+/*
+record a report for the review. Once threshold open reports are reached a
+visible review is held as pending until a moderator decides, threshold 0 never
+holds reviews. Reports true if this report put the review on hold
+*/
+func (m *ModerationModel) AddReport(review *Review, report *ReviewReport, threshold int) (bool, error) {
+	query := `
+	INSERT INTO review_reports (review_id, user_id, reason, comment)
+	VALUES ($1, $2, $3, $4)
+	RETURNING id, created_at
+	`
+	args := []any{report.ReviewID, report.UserID, report.Reason, report.Comment}
+
+	var held bool
+	_, err := withBookRating(m.DB, review.Book_ID, func(ctx context.Context, tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, query, args...).Scan(&report.ID, &report.CreatedAt)
+		if err != nil {
+			switch {
+			case err.Error() == `pq: duplicate key value violates unique constraint "review_reports_open_idx"`:
+				return ErrDuplicateReport
+			default:
+				return err
+			}
+		}
+
+		if threshold < 1 {
+			return nil
+		}
+
+		result, err := tx.ExecContext(ctx, `
+		UPDATE reviews
+		SET status = $2
+		WHERE id = $1 AND status = $3
+		AND (SELECT COUNT(*) FROM review_reports WHERE review_id = $1 AND resolved_at IS NULL) >= $4
+		`, report.ReviewID, ReviewPending, ReviewVisible, threshold)
+		if err != nil {
+			return err
+		}
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		held = rowsAffected > 0
+		if !held {
+			return nil
+		}
+
+		//keep the automatic decision in the history like a moderator's
+		return insertModerationAction(ctx, tx, &ModerationAction{
+			ReviewID: report.ReviewID,
+			Action:   "hold",
+			Status:   ReviewPending,
+			Note:     fmt.Sprintf("held automatically after %d reports", threshold),
+		})
+	})
+	if err != nil {
+		return false, err
+	}
+	if held {
+		review.Status = ReviewPending
+	}
+	return held, nil
+}
+
+/*
+apply a moderator decision to the review: change its state, close the open
+reports when the decision settles them (hide or approve) and record the
+action. The rating of the book follows since only visible reviews count
+*/
+func (m *ModerationModel) Apply(review *Review, action *ModerationAction) error {
+	action.ReviewID = int64(review.ID)
+	action.Status = ModerationActions[action.Action]
+
+	_, err := withBookRating(m.DB, review.Book_ID, func(ctx context.Context, tx *sql.Tx) error {
+		result, err := tx.ExecContext(ctx, `UPDATE reviews SET status = $2 WHERE id = $1`, action.ReviewID, action.Status)
+		if err != nil {
+			return err
+		}
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if rowsAffected == 0 {
+			return ErrRecordNotFound
+		}
+
+		if action.Status != ReviewPending {
+			_, err = tx.ExecContext(ctx, `
+			UPDATE review_reports
+			SET resolved_at = NOW()
+			WHERE review_id = $1 AND resolved_at IS NULL
+			`, action.ReviewID)
+			if err != nil {
+				return err
+			}
+		}
+
+		return insertModerationAction(ctx, tx, action)
+	})
+	if err != nil {
+		return err
+	}
+	review.Status = action.Status
+	return nil
+}
+
+func insertModerationAction(ctx context.Context, tx *sql.Tx, action *ModerationAction) error {
+	query := `
+	INSERT INTO review_moderation_actions (review_id, moderator_id, action, status, note)
+	VALUES ($1, $2, $3, $4, $5)
+	RETURNING id, created_at
+	`
+	args := []any{action.ReviewID, action.ModeratorID, action.Action, action.Status, action.Note}
+
+	return tx.QueryRowContext(ctx, query, args...).Scan(&action.ID, &action.CreatedAt)
+}
+
+/*
+list the reviews waiting for a moderator, the most reported first. status
+narrows the queue to one review state or to "reported" reviews, empty means
+pending reviews and reviews with open reports
+*/
+func (m *ModerationModel) Queue(status string, filters Fileters) ([]*ModerationQueueEntry, Metadata, error) {
+	query := `
+	SELECT COUNT(*) OVER(), reviews.id, reviews.book_id, reviews.user_id, reviews.rating, reviews.review_text,
+		reviews.helpful_count, reviews.created_at, reviews.status, reviews.version,
+		COALESCE(reports.total, 0), COALESCE(reports.reasons, '{}'), reports.first_reported_at
+	FROM reviews
+	LEFT JOIN (
+		SELECT review_id, COUNT(*) AS total, array_agg(DISTINCT reason ORDER BY reason) AS reasons,
+			MIN(created_at) AS first_reported_at
+		FROM review_reports
+		WHERE resolved_at IS NULL
+		GROUP BY review_id
+	) AS reports ON reports.review_id = reviews.id
+	WHERE CASE $1
+		WHEN '' THEN reviews.status = 'pending' OR reports.total > 0
+		WHEN 'reported' THEN reports.total > 0
+		ELSE reviews.status = $1
+	END
+	ORDER BY COALESCE(reports.total, 0) DESC, reports.first_reported_at ASC NULLS LAST, reviews.id ASC
+	LIMIT $2 OFFSET $3
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, status, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	entries := []*ModerationQueueEntry{}
+	for rows.Next() {
+		var review Review
+		var entry ModerationQueueEntry
+		err := rows.Scan(
+			&totalRecords,
+			&review.ID,
+			&review.Book_ID,
+			&review.User_ID,
+			&review.Rating,
+			&review.ReviewText,
+			&review.HelpfulCount,
+			&review.Created_at,
+			&review.Status,
+			&review.Version,
+			&entry.OpenReports,
+			pq.Array(&entry.Reasons),
+			&entry.FirstReportAt,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		entry.Review = &review
+		entries = append(entries, &entry)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetaData(totalRecords, filters.Page, filters.PageSize)
+	return entries, metadata, nil
+}
+
+// every report of a review, open ones first
+func (m *ModerationModel) GetReports(reviewID int64) ([]*ReviewReport, error) {
+	query := `
+	SELECT id, review_id, user_id, reason, comment, created_at, resolved_at
+	FROM review_reports
+	WHERE review_id = $1
+	ORDER BY resolved_at IS NOT NULL, created_at DESC
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, reviewID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reports := []*ReviewReport{}
+	for rows.Next() {
+		var report ReviewReport
+		err := rows.Scan(
+			&report.ID,
+			&report.ReviewID,
+			&report.UserID,
+			&report.Reason,
+			&report.Comment,
+			&report.CreatedAt,
+			&report.ResolvedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		reports = append(reports, &report)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return reports, nil
+}
+
+// the moderation history of a review, newest first
+func (m *ModerationModel) GetActions(reviewID int64) ([]*ModerationAction, error) {
+	query := `
+	SELECT id, review_id, moderator_id, action, status, note, created_at
+	FROM review_moderation_actions
+	WHERE review_id = $1
+	ORDER BY created_at DESC, id DESC
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, reviewID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	actions := []*ModerationAction{}
+	for rows.Next() {
+		var action ModerationAction
+		err := rows.Scan(
+			&action.ID,
+			&action.ReviewID,
+			&action.ModeratorID,
+			&action.Action,
+			&action.Status,
+			&action.Note,
+			&action.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		actions = append(actions, &action)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return actions, nil
+}
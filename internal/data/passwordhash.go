@@ -0,0 +1,186 @@
+package data
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// algorithms a stored password hash can use
+const (
+	HashArgon2id = "argon2id"
+	HashBcrypt   = "bcrypt"
+)
+
+var ErrUnknownPasswordHash = errors.New("unknown password hash format")
+
+/*
+how new password hashes are computed. Every stored hash carries its algorithm
+and parameters (bcrypt "$2a$12$...", argon2id in the PHC format
+"$argon2id$v=19$m=65536,t=3,p=2$salt$key"), so hashes made with older settings
+keep verifying and can be recognised as outdated
+*/
+type PasswordHashParams struct {
+	Algorithm     string
+	BcryptCost    int
+	Argon2Memory  uint32 //KiB
+	Argon2Time    uint32
+	Argon2Threads uint8
+	Argon2SaltLen int
+	Argon2KeyLen  uint32
+}
+
+// the recommended settings, main adjusts them from its flags and hands them to the UserModel
+func DefaultPasswordHashing() PasswordHashParams {
+	return PasswordHashParams{
+		Algorithm:     HashArgon2id,
+		BcryptCost:    12,
+		Argon2Memory:  64 * 1024,
+		Argon2Time:    3,
+		Argon2Threads: 2,
+		Argon2SaltLen: 16,
+		Argon2KeyLen:  32,
+	}
+}
+
+// check the settings before the server starts using them
+func (p PasswordHashParams) Validate() error {
+	switch p.Algorithm {
+	case HashBcrypt:
+		if p.BcryptCost < bcrypt.MinCost || p.BcryptCost > bcrypt.MaxCost {
+			return fmt.Errorf("bcrypt cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+		}
+	case HashArgon2id:
+		if p.Argon2Memory < 8*uint32(p.Argon2Threads) || p.Argon2Time < 1 || p.Argon2Threads < 1 {
+			return errors.New("argon2id needs a time and threads of at least 1 and 8 KiB of memory per thread")
+		}
+		if p.Argon2SaltLen < 8 || p.Argon2KeyLen < 16 {
+			return errors.New("argon2id needs a salt of at least 8 bytes and a key of at least 16 bytes")
+		}
+	default:
+		return fmt.Errorf("unknown password hash algorithm %q", p.Algorithm)
+	}
+	return nil
+}
+
+func (p PasswordHashParams) hash(plainTextPassword string) ([]byte, error) {
+	switch p.Algorithm {
+	case HashBcrypt:
+		return bcrypt.GenerateFromPassword([]byte(plainTextPassword), p.BcryptCost)
+	case HashArgon2id:
+		salt := make([]byte, p.Argon2SaltLen)
+		_, err := rand.Read(salt)
+		if err != nil {
+			return nil, err
+		}
+		key := argon2.IDKey([]byte(plainTextPassword), salt, p.Argon2Time, p.Argon2Memory, p.Argon2Threads, p.Argon2KeyLen)
+		encoded := fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+			argon2.Version, p.Argon2Memory, p.Argon2Time, p.Argon2Threads,
+			base64.RawStdEncoding.EncodeToString(salt),
+			base64.RawStdEncoding.EncodeToString(key),
+		)
+		return []byte(encoded), nil
+	default:
+		return nil, fmt.Errorf("unknown password hash algorithm %q", p.Algorithm)
+	}
+}
+
+// a decoded argon2id hash
+type argon2Hash struct {
+	memory  uint32
+	time    uint32
+	threads uint8
+	salt    []byte
+	key     []byte
+}
+
+func decodeArgon2Hash(hash []byte) (*argon2Hash, error) {
+	parts := strings.Split(string(hash), "$")
+	if len(parts) != 6 || parts[1] != HashArgon2id {
+		return nil, ErrUnknownPasswordHash
+	}
+
+	var version int
+	_, err := fmt.Sscanf(parts[2], "v=%d", &version)
+	if err != nil || version != argon2.Version {
+		return nil, ErrUnknownPasswordHash
+	}
+
+	var h argon2Hash
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &h.memory, &h.time, &h.threads)
+	if err != nil {
+		return nil, ErrUnknownPasswordHash
+	}
+
+	h.salt, err = base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return nil, ErrUnknownPasswordHash
+	}
+	h.key, err = base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(h.key) == 0 {
+		return nil, ErrUnknownPasswordHash
+	}
+	return &h, nil
+}
+
+// which algorithm produced a stored hash
+func hashAlgorithm(hash []byte) string {
+	switch {
+	case bytes.HasPrefix(hash, []byte("$argon2id$")):
+		return HashArgon2id
+	case bytes.HasPrefix(hash, []byte("$2")):
+		return HashBcrypt
+	default:
+		return ""
+	}
+}
+
+func compareHashAndPassword(hash []byte, plainTextPassword string) (bool, error) {
+	switch hashAlgorithm(hash) {
+	case HashBcrypt:
+		err := bcrypt.CompareHashAndPassword(hash, []byte(plainTextPassword))
+		if err != nil {
+			switch {
+			case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword):
+				return false, nil
+			default:
+				return false, err
+			}
+		}
+		return true, nil
+	case HashArgon2id:
+		h, err := decodeArgon2Hash(hash)
+		if err != nil {
+			return false, err
+		}
+		key := argon2.IDKey([]byte(plainTextPassword), h.salt, h.time, h.memory, h.threads, uint32(len(h.key)))
+		return subtle.ConstantTimeCompare(key, h.key) == 1, nil
+	default:
+		return false, ErrUnknownPasswordHash
+	}
+}
+
+// report if a stored hash was made with another algorithm or weaker settings than p
+func (p PasswordHashParams) outdated(hash []byte) bool {
+	if hashAlgorithm(hash) != p.Algorithm {
+		return true
+	}
+
+	switch p.Algorithm {
+	case HashBcrypt:
+		cost, err := bcrypt.Cost(hash)
+		return err != nil || cost != p.BcryptCost
+	case HashArgon2id:
+		h, err := decodeArgon2Hash(hash)
+		return err != nil || h.memory != p.Argon2Memory || h.time != p.Argon2Time ||
+			h.threads != p.Argon2Threads || len(h.salt) != p.Argon2SaltLen || uint32(len(h.key)) != p.Argon2KeyLen
+	}
+	return false
+}
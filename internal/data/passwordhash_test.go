@@ -0,0 +1,159 @@
+package data
+
+import (
+	"errors"
+	"testing"
+)
+
+// cheap settings so the tests stay fast, the format is the same as with the real ones
+func testHashing(algorithm string) PasswordHashParams {
+	params := DefaultPasswordHashing()
+	params.Algorithm = algorithm
+	params.BcryptCost = 4
+	params.Argon2Memory = 64
+	params.Argon2Time = 1
+	params.Argon2Threads = 1
+	return params
+}
+
+func TestPasswordHashRoundTrip(t *testing.T) {
+	for _, algorithm := range []string{HashArgon2id, HashBcrypt} {
+		t.Run(algorithm, func(t *testing.T) {
+			users := UserModel{Hashing: testHashing(algorithm)}
+			user := &User{}
+
+			err := users.SetPassword(user, "correct horse battery staple")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := hashAlgorithm(user.Password.hash); got != algorithm {
+				t.Errorf("got a %q hash; want %q", got, algorithm)
+			}
+
+			match, err := user.Password.Matches("correct horse battery staple")
+			if err != nil || !match {
+				t.Errorf("right password: got %t, %v", match, err)
+			}
+			match, err = user.Password.Matches("correct horse battery stapler")
+			if err != nil || match {
+				t.Errorf("wrong password: got %t, %v", match, err)
+			}
+
+			if users.NeedsRehash(user) {
+				t.Error("a fresh hash is reported as outdated")
+			}
+		})
+	}
+}
+
+// two hashes of the same password differ because of the salt
+func TestPasswordHashSalted(t *testing.T) {
+	params := testHashing(HashArgon2id)
+
+	first, err := params.hash("correct horse battery staple")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := params.hash("correct horse battery staple")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(first) == string(second) {
+		t.Error("the same password hashed twice gave the same hash")
+	}
+}
+
+func TestPasswordHashOutdated(t *testing.T) {
+	argon2Hash, err := testHashing(HashArgon2id).hash("correct horse battery staple")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bcryptHash, err := testHashing(HashBcrypt).hash("correct horse battery staple")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		hash   []byte
+		change func(p *PasswordHashParams)
+		want   bool
+	}{
+		{"argon2id unchanged", argon2Hash, func(p *PasswordHashParams) {}, false},
+		{"argon2id memory", argon2Hash, func(p *PasswordHashParams) { p.Argon2Memory *= 2 }, true},
+		{"argon2id time", argon2Hash, func(p *PasswordHashParams) { p.Argon2Time++ }, true},
+		{"argon2id threads", argon2Hash, func(p *PasswordHashParams) { p.Argon2Threads++ }, true},
+		{"argon2id salt length", argon2Hash, func(p *PasswordHashParams) { p.Argon2SaltLen++ }, true},
+		{"argon2id key length", argon2Hash, func(p *PasswordHashParams) { p.Argon2KeyLen++ }, true},
+		{"argon2id to bcrypt", argon2Hash, func(p *PasswordHashParams) { p.Algorithm = HashBcrypt }, true},
+		{"argon2id, bcrypt cost", argon2Hash, func(p *PasswordHashParams) { p.BcryptCost++ }, false},
+		{"bcrypt unchanged", bcryptHash, func(p *PasswordHashParams) { p.Algorithm = HashBcrypt }, false},
+		{"bcrypt cost", bcryptHash, func(p *PasswordHashParams) { p.Algorithm = HashBcrypt; p.BcryptCost++ }, true},
+		{"bcrypt to argon2id", bcryptHash, func(p *PasswordHashParams) {}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params := testHashing(HashArgon2id)
+			tt.change(&params)
+
+			if got := params.outdated(tt.hash); got != tt.want {
+				t.Errorf("got %t; want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPasswordHashMalformed(t *testing.T) {
+	params := testHashing(HashArgon2id)
+
+	for _, hash := range []string{
+		"",
+		"plaintext",
+		"$argon2id$",
+		"$argon2id$v=19$m=64,t=1,p=1$c2FsdHNhbHQ",
+		"$argon2id$v=18$m=64,t=1,p=1$c2FsdHNhbHQ$a2V5a2V5a2V5a2V5",
+		"$argon2id$v=19$m=sixty,t=1,p=1$c2FsdHNhbHQ$a2V5a2V5a2V5a2V5",
+		"$argon2id$v=19$m=64,t=1,p=1$not base64!$a2V5a2V5a2V5a2V5",
+		"$argon2id$v=19$m=64,t=1,p=1$c2FsdHNhbHQ$",
+		"$argon2i$v=19$m=64,t=1,p=1$c2FsdHNhbHQ$a2V5a2V5a2V5a2V5",
+	} {
+		match, err := compareHashAndPassword([]byte(hash), "correct horse battery staple")
+		if match || !errors.Is(err, ErrUnknownPasswordHash) {
+			t.Errorf("%q: got %t, %v; want ErrUnknownPasswordHash", hash, match, err)
+		}
+		if !params.outdated([]byte(hash)) {
+			t.Errorf("%q: a malformed hash is not reported as outdated", hash)
+		}
+	}
+}
+
+func TestPasswordHashParamsValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(p *PasswordHashParams)
+		valid  bool
+	}{
+		{"defaults", func(p *PasswordHashParams) {}, true},
+		{"bcrypt", func(p *PasswordHashParams) { p.Algorithm = HashBcrypt }, true},
+		{"bcrypt cost too low", func(p *PasswordHashParams) { p.Algorithm = HashBcrypt; p.BcryptCost = 3 }, false},
+		{"bcrypt cost too high", func(p *PasswordHashParams) { p.Algorithm = HashBcrypt; p.BcryptCost = 32 }, false},
+		{"argon2id no time", func(p *PasswordHashParams) { p.Argon2Time = 0 }, false},
+		{"argon2id no threads", func(p *PasswordHashParams) { p.Argon2Threads = 0 }, false},
+		{"argon2id too little memory", func(p *PasswordHashParams) { p.Argon2Memory = 8 }, false},
+		{"argon2id short salt", func(p *PasswordHashParams) { p.Argon2SaltLen = 4 }, false},
+		{"unknown algorithm", func(p *PasswordHashParams) { p.Algorithm = "md5" }, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params := DefaultPasswordHashing()
+			tt.change(&params)
+
+			err := params.Validate()
+			if (err == nil) != tt.valid {
+				t.Errorf("got %v; want valid %t", err, tt.valid)
+			}
+		})
+	}
+}
@@ -0,0 +1,166 @@
+package data
+
+import (
+	"bufio"
+	"crypto/sha1"
+	_ "embed"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode"
+
+	"github.com/abner-tech/Test3-Api.git/internal/validator"
+)
+
+// a source of passwords that must not be used, e.g. because they appeared in a breach
+type PasswordList interface {
+	Contains(password string) bool
+}
+
+/*
+rules for new passwords (registration, reset and change). Logins do not go
+through the policy so tightening it never locks anyone out of their account
+*/
+type PasswordPolicy struct {
+	MinLength      int
+	MinClasses     int  //how many of lower case, upper case, digits and symbols must be used
+	RejectPersonal bool //refuse passwords containing the username or email
+	Breached       PasswordList
+}
+
+/*
+check a new password, personal holds the username and email of its owner. The
+policy is checked on its own so errors already in v (e.g. the username) do not
+hide problems with the password
+*/
+func (p *PasswordPolicy) Validate(v *validator.Validator, password string, personal ...string) {
+	pv := validator.New()
+	p.validate(pv, password, personal)
+
+	for key, message := range pv.Errors {
+		v.AddError(key, message)
+	}
+}
+
+func (p *PasswordPolicy) validate(v *validator.Validator, password string, personal []string) {
+	ValidatePassword(v, password)
+	if !v.IsEmpty() {
+		return
+	}
+
+	v.Check(len(password) >= p.MinLength, "password", fmt.Sprintf("must be at least %d bytes long", p.MinLength))
+	v.Check(characterClasses(password) >= p.MinClasses, "password",
+		fmt.Sprintf("must use at least %d of: lower case letters, upper case letters, digits and symbols", p.MinClasses))
+
+	if p.RejectPersonal {
+		v.Check(!containsPersonal(password, personal), "password", "must not contain your username or email address")
+	}
+
+	if p.Breached != nil {
+		v.Check(!p.Breached.Contains(password), "password", "is too common or has appeared in a data breach, please choose another")
+	}
+}
+
+func characterClasses(password string) int {
+	var lower, upper, digit, symbol int
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = 1
+		case unicode.IsUpper(r):
+			upper = 1
+		case unicode.IsDigit(r):
+			digit = 1
+		default:
+			symbol = 1
+		}
+	}
+	return lower + upper + digit + symbol
+}
+
+// the password contains the username, the email or the part of the email before the @
+func containsPersonal(password string, personal []string) bool {
+	password = strings.ToLower(password)
+
+	for _, value := range personal {
+		value = strings.ToLower(strings.TrimSpace(value))
+		candidates := []string{value}
+		if local, _, found := strings.Cut(value, "@"); found {
+			candidates = append(candidates, local)
+		}
+
+		for _, candidate := range candidates {
+			//very short names would match far too many passwords
+			if len(candidate) >= 3 && strings.Contains(password, candidate) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// known passwords stored as SHA-1 hashes, the format of the Pwned Passwords downloads
+type BreachedPasswords struct {
+	hashes map[[sha1.Size]byte]struct{}
+}
+
+//go:embed breached_passwords.txt
+var bundledBreachedPasswords string
+
+// the small list of very common passwords that ships with the API
+func BundledBreachedPasswords() *BreachedPasswords {
+	list := &BreachedPasswords{hashes: make(map[[sha1.Size]byte]struct{})}
+	//the bundled file is part of the source tree, it cannot be malformed
+	err := list.read(strings.NewReader(bundledBreachedPasswords))
+	if err != nil {
+		panic(err)
+	}
+	return list
+}
+
+// add the hashes from a file, one upper or lower case hex SHA-1 per line, optionally followed by :count
+func (b *BreachedPasswords) LoadFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	err = b.read(file)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+func (b *BreachedPasswords) read(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		hexHash, _, _ := strings.Cut(text, ":")
+		if len(hexHash) != 2*sha1.Size {
+			return fmt.Errorf("line %d: not a SHA-1 hash", line)
+		}
+
+		var hash [sha1.Size]byte
+		_, err := hex.Decode(hash[:], []byte(hexHash))
+		if err != nil {
+			return fmt.Errorf("line %d: not a SHA-1 hash", line)
+		}
+		b.hashes[hash] = struct{}{}
+	}
+	return scanner.Err()
+}
+
+func (b *BreachedPasswords) Contains(password string) bool {
+	_, found := b.hashes[sha1.Sum([]byte(password))]
+	return found
+}
@@ -0,0 +1,58 @@
+package data
+
+import (
+	"testing"
+
+	"github.com/abner-tech/Test3-Api.git/internal/validator"
+)
+
+func TestPasswordPolicyValidate(t *testing.T) {
+	policy := &PasswordPolicy{
+		MinLength:      10,
+		MinClasses:     2,
+		RejectPersonal: true,
+		Breached:       BundledBreachedPasswords(),
+	}
+
+	tests := []struct {
+		name     string
+		password string
+		valid    bool
+	}{
+		{"good", "correct horse 42", true},
+		{"empty", "", false},
+		{"too long", string(make([]byte, 73)), false},
+		{"too short", "short 4", false},
+		{"one class", "correcthorsebattery", false},
+		{"username", "janedoe-2024!", false},
+		{"email", "Jane@Example.com-1", false},
+		{"breached", "12345678", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := validator.New()
+			policy.Validate(v, tt.password, "janedoe", "jane@example.com")
+
+			if v.IsEmpty() != tt.valid {
+				t.Errorf("got errors %v; want valid %t", v.Errors, tt.valid)
+			}
+		})
+	}
+}
+
+// problems elsewhere in the request must not hide the problems with the password
+func TestPasswordPolicyValidateWithOtherErrors(t *testing.T) {
+	policy := &PasswordPolicy{MinLength: 10, MinClasses: 1}
+
+	v := validator.New()
+	v.AddError("username", "must be provided")
+	policy.Validate(v, "short", "", "")
+
+	if _, found := v.Errors["password"]; !found {
+		t.Errorf("got errors %v; want a password error", v.Errors)
+	}
+	if _, found := v.Errors["username"]; !found {
+		t.Errorf("got errors %v; want the username error kept", v.Errors)
+	}
+}
@@ -16,18 +16,53 @@ type PermissionsModel struct {
 	DB *sql.DB
 }
 
+// actions recorded in the permissions audit trail
+const PermissionGrant = "grant"
+const PermissionRevoke = "revoke"
+const PermissionAssignRole = "assign_role"
+const PermissionUnassignRole = "unassign_role"
+
+// one entry of the permissions audit trail
+type PermissionAudit struct {
+	ID        int64     `json:"id"`
+	ActorID   int64     `json:"actor_id"`
+	UserID    int64     `json:"user_id"`
+	Action    string    `json:"action"`
+	Role      string    `json:"role,omitempty"`
+	Codes     []string  `json:"codes"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
 func (p Permissions) Include(code string) bool {
 	return slices.Contains(p, code)
 }
 
+// the codes present in both p and other
+func (p Permissions) Intersect(other Permissions) Permissions {
+	both := Permissions{}
+	for _, code := range p {
+		if other.Include(code) {
+			both = append(both, code)
+		}
+	}
+	return both
+}
+
+// effective permissions for the user: the ones granted directly plus the ones granted through their roles
 func (p *PermissionsModel) GetAllForUser(userID int64) (Permissions, error) {
 	query := `
 	SELECT permissions.code 
 	FROM permissions
 	INNER JOIN users_permissions ON
 	users_permissions.permission_id = permissions.id
-	INNER JOIN users ON users_permissions.user_id = users.id
-	WHERE users.id = $1
+	WHERE users_permissions.user_id = $1
+	UNION
+	SELECT permissions.code
+	FROM permissions
+	INNER JOIN roles_permissions ON
+	roles_permissions.permission_id = permissions.id
+	INNER JOIN users_roles ON users_roles.role_id = roles_permissions.role_id
+	WHERE users_roles.user_id = $1
 	`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
@@ -65,6 +100,7 @@ func (p *PermissionsModel) AddForUser(userID int64, codes ...string) error {
 	SELECT $1, permissions.id
 	FROM permissions
 	WHERE permissions.code = ANY($2)
+	ON CONFLICT DO NOTHING
 	`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
@@ -75,3 +111,196 @@ func (p *PermissionsModel) AddForUser(userID int64, codes ...string) error {
 
 	return err
 }
+
+// grant permissions directly to the user and record the grant in the audit trail, in one transaction.
+// only the codes the user did not hold before are recorded, nil is returned when nothing changed
+func (p *PermissionsModel) GrantForUser(actorID, userID int64, codes ...string) (*PermissionAudit, error) {
+	query := `
+	WITH added AS (
+		INSERT INTO users_permissions
+		SELECT $1, permissions.id
+		FROM permissions
+		WHERE permissions.code = ANY($2)
+		ON CONFLICT DO NOTHING
+		RETURNING permission_id
+	)
+	SELECT permissions.code
+	FROM added
+	INNER JOIN permissions ON permissions.id = added.permission_id
+	ORDER BY permissions.code
+	`
+
+	return p.changeForUser(query, &PermissionAudit{ActorID: actorID, UserID: userID, Action: PermissionGrant}, codes)
+}
+
+// remove directly granted permissions from the user and record the revoke in the audit trail, in one transaction.
+// permissions that come from roles are not affected, nil is returned when nothing changed
+func (p *PermissionsModel) RevokeForUser(actorID, userID int64, codes ...string) (*PermissionAudit, error) {
+	query := `
+	DELETE FROM users_permissions
+	USING permissions
+	WHERE users_permissions.permission_id = permissions.id
+	AND users_permissions.user_id = $1
+	AND permissions.code = ANY($2)
+	RETURNING permissions.code
+	`
+
+	return p.changeForUser(query, &PermissionAudit{ActorID: actorID, UserID: userID, Action: PermissionRevoke}, codes)
+}
+
+// run a grant or revoke query returning the changed codes and audit them in the same transaction
+func (p *PermissionsModel) changeForUser(query string, entry *PermissionAudit, codes []string) (*PermissionAudit, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := p.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, query, entry.UserID, pq.Array(codes))
+	if err != nil {
+		return nil, err
+	}
+	changed := []string{}
+	for rows.Next() {
+		var code string
+		err := rows.Scan(&code)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		changed = append(changed, code)
+	}
+	rows.Close()
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	//a no-op grant or revoke leaves no trace in the audit trail
+	if len(changed) == 0 {
+		return nil, tx.Commit()
+	}
+
+	slices.Sort(changed)
+	entry.Codes = changed
+	err = insertPermissionAudit(ctx, tx, entry)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// permissions granted to the user directly, without the ones that come from roles
+func (p *PermissionsModel) GetGrantedForUser(userID int64) (Permissions, error) {
+	query := `
+	SELECT permissions.code
+	FROM permissions
+	INNER JOIN users_permissions ON
+	users_permissions.permission_id = permissions.id
+	WHERE users_permissions.user_id = $1
+	ORDER BY permissions.code
+	`
+
+	return p.queryCodes(query, userID)
+}
+
+// every permission code known to the system
+func (p *PermissionsModel) ListAll() (Permissions, error) {
+	query := `
+	SELECT code
+	FROM permissions
+	ORDER BY code
+	`
+
+	return p.queryCodes(query)
+}
+
+// run a query returning a single column of permission codes
+func (p *PermissionsModel) queryCodes(query string, args ...any) (Permissions, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := p.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	permissions := Permissions{}
+	for rows.Next() {
+		var code string
+		err := rows.Scan(&code)
+		if err != nil {
+			return nil, err
+		}
+		permissions = append(permissions, code)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return permissions, nil
+}
+
+// record who changed which permissions for a user, inside the transaction that made the change
+func insertPermissionAudit(ctx context.Context, tx *sql.Tx, entry *PermissionAudit) error {
+	query := `
+	INSERT INTO permissions_audit (actor_id, user_id, action, role, codes)
+	VALUES ($1, $2, $3, NULLIF($4, ''), $5)
+	RETURNING id, created_at
+	`
+
+	args := []any{entry.ActorID, entry.UserID, entry.Action, entry.Role, pq.Array(entry.Codes)}
+
+	return tx.QueryRowContext(ctx, query, args...).Scan(&entry.ID, &entry.CreatedAt)
+}
+
+// the permission change history of a user, newest first
+func (p *PermissionsModel) GetAuditForUser(userID int64) ([]*PermissionAudit, error) {
+	query := `
+	SELECT id, COALESCE(actor_id, 0), user_id, action, COALESCE(role, ''), codes, created_at
+	FROM permissions_audit
+	WHERE user_id = $1
+	ORDER BY created_at DESC, id DESC
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := p.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []*PermissionAudit{}
+	for rows.Next() {
+		var entry PermissionAudit
+		err := rows.Scan(
+			&entry.ID,
+			&entry.ActorID,
+			&entry.UserID,
+			&entry.Action,
+			&entry.Role,
+			pq.Array(&entry.Codes),
+			&entry.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, &entry)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
@@ -0,0 +1,54 @@
+package data
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestGrantForUserAuditsOnlyChangedCodes(t *testing.T) {
+	db := newTestDB(t)
+	admin := newTestUser(t, db)
+	user := newTestUser(t, db)
+	permissions := PermissionsModel{DB: db}
+
+	entry, err := permissions.GrantForUser(admin.ID, user.ID, "books:read")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry == nil || !slices.Equal(entry.Codes, []string{"books:read"}) {
+		t.Fatalf("first grant: got %+v; want codes [books:read]", entry)
+	}
+
+	//books:read is already held, so only books:write is a change
+	entry, err = permissions.GrantForUser(admin.ID, user.ID, "books:read", "books:write")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry == nil || !slices.Equal(entry.Codes, []string{"books:write"}) {
+		t.Fatalf("second grant: got %+v; want codes [books:write]", entry)
+	}
+
+	entry, err = permissions.GrantForUser(admin.ID, user.ID, "books:read")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry != nil {
+		t.Errorf("no-op grant: got %+v; want nil", entry)
+	}
+
+	entry, err = permissions.RevokeForUser(admin.ID, user.ID, "books:write", "books:write")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry == nil || entry.Action != PermissionRevoke || !slices.Equal(entry.Codes, []string{"books:write"}) {
+		t.Fatalf("revoke: got %+v; want revoke of [books:write]", entry)
+	}
+
+	audit, err := permissions.GetAuditForUser(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(audit) != 3 {
+		t.Errorf("got %d audit entries; want 3", len(audit))
+	}
+}
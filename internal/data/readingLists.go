@@ -19,7 +19,7 @@ type Reading_List struct {
 	ID          int64     `json:"id"`
 	Name        string    `json:"name"`
 	Description string    `json:"description"`
-	CreatedBy   int64     `json:"created_by"`
+	CreatedBy   int64     `json:"created_by"` // 0 when the list has no owner
 	CreatedAt   time.Time `json:"-"`
 	Version     int16     `json:"version"`
 }
@@ -83,7 +83,7 @@ func (r *ReadingListModel) CreateReadingList(reading_List *Reading_List) error {
 // fetch all reading lists for all users PAGINATION used
 func (r *ReadingListModel) GetAll(description string, filters Fileters) ([]*Reading_List, Metadata, error) {
 	query := fmt.Sprintf(`
-	SELECT COUNT(*) OVER(), id, name, description, created_at, created_by, version
+	SELECT COUNT(*) OVER(), id, name, description, created_at, COALESCE(created_by, 0), version
 	FROM reading_lists
 	WHERE (to_tsvector('simple',description) @@
 		plainto_tsquery('simple', $1) OR $1 = '')
@@ -141,7 +141,7 @@ func (r *ReadingListModel) GetByID(id int64) (*Reading_List, error) {
 
 	//query
 	query := `
-	SELECT id, name, description, created_by, created_at, version
+	SELECT id, name, description, COALESCE(created_by, 0), created_at, version
 	FROM reading_lists
 	WHERE id = $1
 	`
@@ -15,6 +15,19 @@ type ReviewModel struct {
 	DB *sql.DB
 }
 
+// states of a review, only visible reviews are shown to everyone and count towards the rating of the book
+const (
+	ReviewVisible = "visible"
+	ReviewHidden  = "hidden"
+	ReviewPending = "pending"
+)
+
+// who is looking at reviews, moderators see every review and authors always see their own
+type ReviewViewer struct {
+	UserID    int64
+	Moderator bool
+}
+
 type Review struct {
 	ID           int32     `json:"id"`
 	Book_ID      int64     `json:"book_id"`
@@ -23,6 +36,7 @@ type Review struct {
 	ReviewText   string    `json:"review_text"`
 	HelpfulCount int32     `json:"helpful_count"`
 	Created_at   time.Time `json:"created_at"`
+	Status       string    `json:"status"`
 	Version      int16     `json:"version"`
 }
 
@@ -41,32 +55,74 @@ func (r *ReviewModel) InsertReview(review *Review) error {
 	query := `
 	INSERT INTO reviews (book_id, user_id, rating, review_text)
 	VALUES ($1, $2, $3, $4)
-	RETURNING id, helpful_count, created_at, version
+	RETURNING id, helpful_count, created_at, status, version
 	`
 	args := []any{review.Book_ID, review.User_ID, review.Rating, review.ReviewText}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+	//the rating of the book changes with the review
+	_, err := withBookRating(r.DB, review.Book_ID, func(ctx context.Context, tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, query, args...).Scan(
+			&review.ID,
+			&review.HelpfulCount,
+			&review.Created_at,
+			&review.Status,
+			&review.Version,
+		)
+		if err != nil {
+			switch {
+			case err.Error() == `pq: duplicate key value violates unique constraint "reviews_book_user_key"`:
+				return ErrDuplicateReview
+			default:
+				return err
+			}
+		}
+		return nil
+	})
+	return err
+}
 
-	return r.DB.QueryRowContext(ctx, query, args...).Scan(
-		&review.ID,
-		&review.HelpfulCount,
-		&review.Created_at,
-		&review.Version,
-	)
+// create the review of the user for the book or replace the one they already wrote, reports if it was created
+func (r *ReviewModel) UpsertReview(review *Review) (bool, error) {
+	//xmax is only set on a row version that replaced another, so it is 0 for a fresh insert
+	query := `
+	INSERT INTO reviews (book_id, user_id, rating, review_text)
+	VALUES ($1, $2, $3, $4)
+	ON CONFLICT (book_id, user_id) DO UPDATE
+	SET rating = EXCLUDED.rating, review_text = EXCLUDED.review_text, version = reviews.version + 1
+	RETURNING id, helpful_count, created_at, status, version, xmax = 0
+	`
+	args := []any{review.Book_ID, review.User_ID, review.Rating, review.ReviewText}
+
+	var created bool
+	_, err := withBookRating(r.DB, review.Book_ID, func(ctx context.Context, tx *sql.Tx) error {
+		return tx.QueryRowContext(ctx, query, args...).Scan(
+			&review.ID,
+			&review.HelpfulCount,
+			&review.Created_at,
+			&review.Status,
+			&review.Version,
+			&created,
+		)
+	})
+	return created, err
 }
 
-func (r *ReviewModel) GetAllReviews(filters Fileters) ([]*Review, Metadata, error) {
+// list the reviews of a book the viewer may see, minRating 0 includes every rating
+func (r *ReviewModel) GetAllForBook(bookID int64, minRating int, viewer ReviewViewer, filters Fileters) ([]*Review, Metadata, error) {
 	query := fmt.Sprintf(`
-	SELECT COUNT(*) OVER(), id, book_id, user_id, rating, review_text, helpful_count, created_at, version
+	SELECT COUNT(*) OVER(), id, book_id, user_id, rating, review_text, helpful_count, created_at, status, version
 	FROM reviews
+	WHERE book_id = $1
+	AND (rating >= $2 OR $2 = 0)
+	AND (status = 'visible' OR $3 OR user_id = $4)
 	ORDER BY %s %s, id ASC
-	LIMIT $1 OFFSET $2
+	LIMIT $5 OFFSET $6
 	`, filters.sortColumn(), filters.sortDirection())
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	rows, err := r.DB.QueryContext(ctx, query, filters.limit(), filters.offset())
+	args := []any{bookID, minRating, viewer.Moderator, viewer.UserID, filters.limit(), filters.offset()}
+	rows, err := r.DB.QueryContext(ctx, query, args...)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
@@ -91,6 +147,7 @@ func (r *ReviewModel) GetAllReviews(filters Fileters) ([]*Review, Metadata, erro
 			&review.ReviewText,
 			&review.HelpfulCount,
 			&review.Created_at,
+			&review.Status,
 			&review.Version,
 		)
 		if err != nil {
@@ -110,7 +167,7 @@ func (r *ReviewModel) GetAllReviews(filters Fileters) ([]*Review, Metadata, erro
 
 func (r *ReviewModel) GetByID(id int64) (*Review, error) {
 	query := `
-	SELECT id, book_id, user_id, rating, review_text, helpful_count, created_at, version
+	SELECT id, book_id, user_id, rating, review_text, helpful_count, created_at, status, version
 	FROM reviews
 	WHERE id = $1
 	`
@@ -128,6 +185,7 @@ func (r *ReviewModel) GetByID(id int64) (*Review, error) {
 		&review.ReviewText,
 		&review.HelpfulCount,
 		&review.Created_at,
+		&review.Status,
 		&review.Version,
 	)
 
@@ -152,12 +210,22 @@ func (r *ReviewModel) UpdateReview(review *Review) error {
 	RETURNING version
 	`
 	args := []any{review.Rating, review.ReviewText, review.ID, review.Book_ID, review.Version}
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
 
-	return r.DB.QueryRowContext(ctx, query, args...).Scan(
-		&review.Version,
-	)
+	_, err := withBookRating(r.DB, review.Book_ID, func(ctx context.Context, tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, query, args...).Scan(
+			&review.Version,
+		)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrEditConfilct
+			default:
+				return err
+			}
+		}
+		return nil
+	})
+	return err
 }
 
 func (r *ReviewModel) DeleteReview(id int64) error {
@@ -165,43 +233,50 @@ func (r *ReviewModel) DeleteReview(id int64) error {
 		return ErrRecordNotFound
 	}
 
+	review, err := r.GetByID(id)
+	if err != nil {
+		return err
+	}
+
 	query := `
 	DELETE FROM reviews
 	WHERE id = $1
 	`
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
 
-	//excecute the query
-	result, err := r.DB.ExecContext(ctx, query, id)
-	if err != nil {
-		return err
-	}
-
-	//check if any rows affected
-	rowsAffected, err := result.RowsAffected()
-	if err != nil {
-		return err
-	}
+	_, err = withBookRating(r.DB, review.Book_ID, func(ctx context.Context, tx *sql.Tx) error {
+		//excecute the query
+		result, err := tx.ExecContext(ctx, query, id)
+		if err != nil {
+			return err
+		}
 
-	if rowsAffected == 0 {
-		return ErrRecordNotFound //no rows affected
-	}
+		//check if any rows affected
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
 
-	return nil
+		if rowsAffected == 0 {
+			return ErrRecordNotFound //no rows affected
+		}
+		return nil
+	})
+	return err
 }
 
-func (r *ReviewModel) GetAllByUserID(user_id int64) ([]*Review, error) {
+// list the reviews written by a user that the viewer may see
+func (r *ReviewModel) GetAllByUserID(user_id int64, viewer ReviewViewer) ([]*Review, error) {
 	query := `
-	SELECT id, book_id, user_id, rating, review_text, helpful_count, created_at, version
+	SELECT id, book_id, user_id, rating, review_text, helpful_count, created_at, status, version
 	FROM reviews
 	WHERE user_id = $1
+	AND (status = 'visible' OR $2 OR user_id = $3)
 	`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	rows, err := r.DB.QueryContext(ctx, query, user_id)
+	rows, err := r.DB.QueryContext(ctx, query, user_id, viewer.Moderator, viewer.UserID)
 	if err != nil {
 		return nil, err
 	}
@@ -220,6 +295,7 @@ func (r *ReviewModel) GetAllByUserID(user_id int64) ([]*Review, error) {
 			&review.ReviewText,
 			&review.HelpfulCount,
 			&review.Created_at,
+			&review.Status,
 			&review.Version,
 		)
 		if err != nil {
@@ -234,3 +310,67 @@ func (r *ReviewModel) GetAllByUserID(user_id int64) ([]*Review, error) {
 
 	return reviews, nil
 }
+
+/*
+record that the user found the review helpful and count the vote, in one
+statement so the counter always matches the votes. Voting twice changes nothing
+and users cannot vote for their own reviews. Returns the new count and whether a
+vote was added
+*/
+func (r *ReviewModel) AddHelpfulVote(reviewID, userID int64) (int32, bool, error) {
+	query := `
+	WITH vote AS (
+		INSERT INTO review_helpful_votes (review_id, user_id)
+		SELECT id, $2 FROM reviews WHERE id = $1 AND user_id <> $2
+		ON CONFLICT DO NOTHING
+		RETURNING review_id
+	), counted AS (
+		UPDATE reviews
+		SET helpful_count = helpful_count + 1
+		WHERE id IN (SELECT review_id FROM vote)
+		RETURNING helpful_count
+	)
+	SELECT COALESCE((SELECT helpful_count FROM counted), (SELECT helpful_count FROM reviews WHERE id = $1)),
+		EXISTS (SELECT 1 FROM vote)
+	`
+
+	return r.changeHelpfulVote(query, reviewID, userID)
+}
+
+// take back the vote of the user, returns the new count and whether there was a vote to remove
+func (r *ReviewModel) RemoveHelpfulVote(reviewID, userID int64) (int32, bool, error) {
+	query := `
+	WITH vote AS (
+		DELETE FROM review_helpful_votes
+		WHERE review_id = $1 AND user_id = $2
+		RETURNING review_id
+	), counted AS (
+		UPDATE reviews
+		SET helpful_count = helpful_count - 1
+		WHERE id IN (SELECT review_id FROM vote)
+		RETURNING helpful_count
+	)
+	SELECT COALESCE((SELECT helpful_count FROM counted), (SELECT helpful_count FROM reviews WHERE id = $1)),
+		EXISTS (SELECT 1 FROM vote)
+	`
+
+	return r.changeHelpfulVote(query, reviewID, userID)
+}
+
+func (r *ReviewModel) changeHelpfulVote(query string, reviewID, userID int64) (int32, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var count sql.NullInt32
+	var changed bool
+	err := r.DB.QueryRowContext(ctx, query, reviewID, userID).Scan(&count, &changed)
+	if err != nil {
+		return 0, false, err
+	}
+
+	//no count at all means there is no such review
+	if !count.Valid {
+		return 0, false, ErrRecordNotFound
+	}
+	return count.Int32, changed, nil
+}
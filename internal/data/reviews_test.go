@@ -0,0 +1,122 @@
+package data
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/abner-tech/Test3-Api.git/internal/validator"
+)
+
+func TestValidateReviewRating(t *testing.T) {
+	tests := []struct {
+		rating float32
+		valid  bool
+	}{
+		{0, true},
+		{1, true},
+		{3.5, true},
+		{5, true},
+		{-1, false},
+		{5.5, false},
+	}
+
+	for _, tt := range tests {
+		v := validator.New()
+		ValidateReview(v, &Review{Rating: tt.rating, ReviewText: "a good read"})
+
+		if v.IsEmpty() != tt.valid {
+			t.Errorf("rating %v: got errors %v; want valid %t", tt.rating, v.Errors, tt.valid)
+		}
+	}
+}
+
+func TestStarBucket(t *testing.T) {
+	db := newTestDB(t)
+
+	//half stars round up and a 0 rating is clamped into the 1 star bucket
+	tests := []struct {
+		rating float64
+		bucket int
+	}{
+		{0, 1},
+		{1, 1},
+		{1.49, 1},
+		{1.5, 2},
+		{2.5, 3},
+		{4.49, 4},
+		{4.5, 5},
+		{5, 5},
+	}
+
+	for _, tt := range tests {
+		var bucket int
+		err := db.QueryRow(`SELECT star_bucket($1)`, tt.rating).Scan(&bucket)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bucket != tt.bucket {
+			t.Errorf("rating %v: got bucket %d; want %d", tt.rating, bucket, tt.bucket)
+		}
+	}
+}
+
+func TestBookRatingAggregate(t *testing.T) {
+	db := newTestDB(t)
+	books := BookModel{DB: db}
+	reviews := ReviewModel{DB: db}
+
+	book := &Book{
+		Title:            "Rating test",
+		Authors:          []string{"Someone"},
+		ISBN:             time.Now().UnixNano() % 1e13,
+		Publication_Date: time.Now(),
+		Genre:            []string{"test"},
+		Description:      "a book to rate",
+	}
+	err := books.Insert(book)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { books.DeleteBook(book.ID) })
+
+	var hidden *Review
+	for _, rating := range []float32{1, 4, 5} {
+		review := &Review{Book_ID: book.ID, User_ID: newTestUser(t, db).ID, Rating: rating, ReviewText: "a good read"}
+		err := reviews.InsertReview(review)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hidden = review
+	}
+
+	checkRating(t, books, book.ID, 3.33, 3, []int64{1, 0, 0, 1, 1})
+
+	//hidden reviews no longer count
+	_, err = db.Exec(`UPDATE reviews SET status = 'hidden' WHERE id = $1`, hidden.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	changed, err := withBookRating(db, book.ID, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !changed {
+		t.Error("hiding a review did not change the rating")
+	}
+
+	checkRating(t, books, book.ID, 2.5, 2, []int64{1, 0, 0, 1, 0})
+}
+
+func checkRating(t *testing.T, books BookModel, bookID int64, average float32, count int32, histogram []int64) {
+	t.Helper()
+
+	book, err := books.GetByID(bookID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if book.Average_Rating != average || book.Rating_Count != count || !slices.Equal(book.Rating_Histogram, histogram) {
+		t.Errorf("got average %v, count %d, histogram %v; want %v, %d, %v",
+			book.Average_Rating, book.Rating_Count, book.Rating_Histogram, average, count, histogram)
+	}
+}
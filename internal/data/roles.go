@@ -0,0 +1,345 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/abner-tech/Test3-Api.git/internal/validator"
+	"github.com/lib/pq"
+)
+
+// role names seeded by the migrations
+const RoleReader = "reader"
+const RoleContributor = "contributor"
+const RoleLibrarian = "librarian"
+const RoleAdmin = "admin"
+
+// a named group of permissions that can be assigned to users
+type Role struct {
+	ID          int64       `json:"id"`
+	Name        string      `json:"name"`
+	Permissions Permissions `json:"permissions"`
+	CreatedAt   time.Time   `json:"created_at"`
+}
+
+type RoleModel struct {
+	DB *sql.DB
+}
+
+func ValidateRole(v *validator.Validator, role *Role) {
+	v.Check(role.Name != "", "name", "must be provided")
+	v.Check(len(role.Name) <= 50, "name", "must not be more than 50 bytes long")
+}
+
+// create a new role together with its initial permissions, nothing is created when a code is unknown
+func (r *RoleModel) Insert(role *Role) error {
+	query := `
+	INSERT INTO roles (name)
+	VALUES ($1)
+	RETURNING id, created_at
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, role.Name).Scan(&role.ID, &role.CreatedAt)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "roles_name_key"`:
+			return ErrDuplicateRoleName
+		default:
+			return err
+		}
+	}
+
+	if len(role.Permissions) > 0 {
+		err = addRolePermissions(ctx, tx, role.ID, role.Permissions)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// list every role together with the permission codes it grants
+func (r *RoleModel) GetAll() ([]*Role, error) {
+	query := `
+	SELECT roles.id, roles.name, roles.created_at,
+	COALESCE(array_agg(permissions.code ORDER BY permissions.code) FILTER (WHERE permissions.code IS NOT NULL), '{}')
+	FROM roles
+	LEFT JOIN roles_permissions ON roles_permissions.role_id = roles.id
+	LEFT JOIN permissions ON permissions.id = roles_permissions.permission_id
+	GROUP BY roles.id
+	ORDER BY roles.id
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := r.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := []*Role{}
+	for rows.Next() {
+		var role Role
+		err := rows.Scan(
+			&role.ID,
+			&role.Name,
+			&role.CreatedAt,
+			pq.Array(&role.Permissions),
+		)
+		if err != nil {
+			return nil, err
+		}
+		roles = append(roles, &role)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
+
+// fetch a single role and the permission codes it grants
+func (r *RoleModel) GetByID(id int64) (*Role, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+	SELECT roles.id, roles.name, roles.created_at,
+	COALESCE(array_agg(permissions.code ORDER BY permissions.code) FILTER (WHERE permissions.code IS NOT NULL), '{}')
+	FROM roles
+	LEFT JOIN roles_permissions ON roles_permissions.role_id = roles.id
+	LEFT JOIN permissions ON permissions.id = roles_permissions.permission_id
+	WHERE roles.id = $1
+	GROUP BY roles.id
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var role Role
+	err := r.DB.QueryRowContext(ctx, query, id).Scan(
+		&role.ID,
+		&role.Name,
+		&role.CreatedAt,
+		pq.Array(&role.Permissions),
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &role, nil
+}
+
+// delete a role, its permission mappings and user assignments cascade
+func (r *RoleModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+	DELETE FROM roles
+	WHERE id = $1
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := r.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// map permission codes to a role, codes the role already has are ignored and unknown codes are rejected
+func (r *RoleModel) AddPermissions(roleID int64, codes ...string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = addRolePermissions(ctx, tx, roleID, codes)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func addRolePermissions(ctx context.Context, tx *sql.Tx, roleID int64, codes []string) error {
+	//the insert below would silently skip codes that don't exist
+	rows, err := tx.QueryContext(ctx, `
+	SELECT DISTINCT code
+	FROM unnest($1::text[]) AS code
+	WHERE code NOT IN (SELECT permissions.code FROM permissions)
+	ORDER BY code
+	`, pq.Array(codes))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	unknown := []string{}
+	for rows.Next() {
+		var code string
+		err := rows.Scan(&code)
+		if err != nil {
+			return err
+		}
+		unknown = append(unknown, code)
+	}
+	err = rows.Err()
+	if err != nil {
+		return err
+	}
+	if len(unknown) > 0 {
+		return fmt.Errorf("%w: %s", ErrUnknownPermission, strings.Join(unknown, ", "))
+	}
+
+	query := `
+	INSERT INTO roles_permissions
+	SELECT $1, permissions.id
+	FROM permissions
+	WHERE permissions.code = ANY($2)
+	ON CONFLICT DO NOTHING
+	`
+
+	_, err = tx.ExecContext(ctx, query, roleID, pq.Array(codes))
+	return err
+}
+
+// remove permission codes from a role
+func (r *RoleModel) RemovePermissions(roleID int64, codes ...string) error {
+	query := `
+	DELETE FROM roles_permissions
+	USING permissions
+	WHERE roles_permissions.permission_id = permissions.id
+	AND roles_permissions.role_id = $1
+	AND permissions.code = ANY($2)
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := r.DB.ExecContext(ctx, query, roleID, pq.Array(codes))
+	return err
+}
+
+// assign roles to a user by name, roles the user already has are ignored
+func (r *RoleModel) AssignToUser(userID int64, names ...string) error {
+	query := `
+	INSERT INTO users_roles
+	SELECT $1, roles.id
+	FROM roles
+	WHERE roles.name = ANY($2)
+	ON CONFLICT DO NOTHING
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := r.DB.ExecContext(ctx, query, userID, pq.Array(names))
+	return err
+}
+
+// assign a role to a user on behalf of actorID and record it in the permissions audit trail, in one transaction.
+// nil is returned when the user already had the role
+func (r *RoleModel) AssignToUserAudited(actorID, userID int64, role *Role) (*PermissionAudit, error) {
+	query := `
+	INSERT INTO users_roles (user_id, role_id)
+	VALUES ($1, $2)
+	ON CONFLICT DO NOTHING
+	`
+
+	entry := &PermissionAudit{ActorID: actorID, UserID: userID, Action: PermissionAssignRole}
+	return r.changeUserRole(query, entry, role, false)
+}
+
+// take a role away from a user on behalf of actorID and record it in the permissions audit trail, in one transaction.
+// ErrRecordNotFound is returned when the user does not have the role
+func (r *RoleModel) RemoveFromUser(actorID, userID int64, role *Role) (*PermissionAudit, error) {
+	query := `
+	DELETE FROM users_roles
+	WHERE user_id = $1 AND role_id = $2
+	`
+
+	entry := &PermissionAudit{ActorID: actorID, UserID: userID, Action: PermissionUnassignRole}
+	return r.changeUserRole(query, entry, role, true)
+}
+
+// run an assignment query for the role and audit it in the same transaction, the audit entry lists the codes the role grants
+func (r *RoleModel) changeUserRole(query string, entry *PermissionAudit, role *Role, mustExist bool) (*PermissionAudit, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, query, entry.UserID, role.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+
+	if rowsAffected == 0 {
+		if mustExist {
+			return nil, ErrRecordNotFound
+		}
+		return nil, nil
+	}
+
+	entry.Role = role.Name
+	entry.Codes = role.Permissions
+	if entry.Codes == nil {
+		entry.Codes = []string{}
+	}
+	err = insertPermissionAudit(ctx, tx, entry)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
@@ -0,0 +1,63 @@
+package data
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	_ "github.com/lib/pq"
+)
+
+/*
+a connection to the database named by TEST3_DB_DSN, which must be migrated to
+the latest version. Tests that need it are skipped when it is not set
+*/
+func newTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	dsn := os.Getenv("TEST3_DB_DSN")
+	if dsn == "" {
+		t.Skip("TEST3_DB_DSN not set")
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	err = db.Ping()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// a throwaway user, deleted again when the test ends
+func newTestUser(t *testing.T, db *sql.DB) *User {
+	t.Helper()
+
+	name := fmt.Sprintf("test%d", time.Now().UnixNano())
+	user := &User{
+		Username:  name,
+		Email:     name + "@example.com",
+		Activated: true,
+	}
+	//the hash is never checked by these tests
+	user.Password.hash = []byte("not a real hash")
+
+	users := UserModel{DB: db}
+	err := users.Insert(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_, err := db.Exec(`DELETE FROM users WHERE id = $1`, user.ID)
+		if err != nil {
+			t.Error(err)
+		}
+	})
+	return user
+}
@@ -6,6 +6,7 @@ import (
 	"crypto/sha256"
 	"database/sql"
 	"encoding/base32"
+	"errors"
 	"time"
 
 	"github.com/abner-tech/Test3-Api.git/internal/validator"
@@ -15,16 +16,34 @@ import (
 const ScopeActivation = "Activation"
 const ScopeAuthentication = "Authentication"
 const ScopePasswordReset = "Password_Reset"
+const ScopeRefresh = "Refresh"
+const ScopeEmailChange = "Email_Change"
+const ScopeTwoFactorChallenge = "Two_Factor_Challenge"
 
 // token definition
 type Token struct {
-	PlainText string    `json:"token"`
-	Hash      []byte    `json:"-"`
-	UserID    int       `json:"-"`
-	Expiry    time.Time `json:"expiry"`
-	Scope     string    `json:"-"`
+	PlainText string     `json:"token"`
+	Hash      []byte     `json:"-"`
+	UserID    int        `json:"-"`
+	Expiry    time.Time  `json:"expiry"`
+	Scope     string     `json:"-"`
+	UserAgent string     `json:"-"`
+	Family    string     `json:"-"` //tokens issued from the same login
+	UsedAt    *time.Time `json:"-"` //refresh tokens can only be used once
 }
 
+// an active authentication token as shown to its owner, never includes the token itself
+type Session struct {
+	CreatedAt  time.Time  `json:"created_at"`
+	Expiry     time.Time  `json:"expiry"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	UserAgent  string     `json:"user_agent"`
+	Current    bool       `json:"current"`
+}
+
+// how stale last_used_at may get, writing it on every request is not worth the load
+const LastUsedResolution = time.Minute
+
 // database access
 type TokenModel struct {
 	DB *sql.DB
@@ -38,15 +57,12 @@ func generateToken(userID int64, ttl time.Duration, scope string) (*Token, error
 		Scope:  scope,
 	}
 
-	//generating the acctual token. creating a byte slice and filling it with random values (rand.read)
-	randoBytes := make([]byte, 16)
-	_, err := rand.Read(randoBytes)
+	//generating the acctual token
+	plainText, err := randomString()
 	if err != nil {
 		return nil, err
 	}
-
-	//encoding the random bytes useing base-32
-	token.PlainText = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randoBytes)
+	token.PlainText = plainText
 
 	//hash the encoding
 	hash := sha256.Sum256([]byte(token.PlainText))
@@ -55,6 +71,23 @@ func generateToken(userID int64, ttl time.Duration, scope string) (*Token, error
 	return token, nil
 }
 
+// 26 character random string. creating a byte slice and filling it with random values (rand.read)
+// and encoding the random bytes useing base-32
+func randomString() (string, error) {
+	randoBytes := make([]byte, 16)
+	_, err := rand.Read(randoBytes)
+	if err != nil {
+		return "", err
+	}
+
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randoBytes), nil
+}
+
+// new identifier for a token family, generated once per login
+func NewTokenFamily() (string, error) {
+	return randomString()
+}
+
 // validate the token client sent to us to be 26 bytes long
 func ValidatetokenPlaintext(v *validator.Validator, tokenPlaintext string) {
 	v.Check(tokenPlaintext != "", "token", "must be provided")
@@ -63,10 +96,17 @@ func ValidatetokenPlaintext(v *validator.Validator, tokenPlaintext string) {
 
 // create and return new token, uses insert as a helper method
 func (t *TokenModel) New(userID int64, ttl time.Duration, scope string) (*Token, error) {
+	return t.NewForClient(userID, ttl, scope, "", "")
+}
+
+// create and return new token remembering which client it was issued to and which family it belongs to
+func (t *TokenModel) NewForClient(userID int64, ttl time.Duration, scope, userAgent, family string) (*Token, error) {
 	token, err := generateToken(userID, ttl, scope)
 	if err != nil {
 		return nil, err
 	}
+	token.UserAgent = userAgent
+	token.Family = family
 
 	err = t.Insert(token)
 	return token, err
@@ -74,11 +114,11 @@ func (t *TokenModel) New(userID int64, ttl time.Duration, scope string) (*Token,
 
 func (t *TokenModel) Insert(token *Token) error {
 	query := `
-	INSERT INTO tokens (hash, user_id, expiry, scope)
-	VALUES ($1, $2, $3, $4)
+	INSERT INTO tokens (hash, user_id, expiry, scope, user_agent, family)
+	VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''))
 	`
 
-	args := []any{token.Hash, token.UserID, token.Expiry, token.Scope}
+	args := []any{token.Hash, token.UserID, token.Expiry, token.Scope, token.UserAgent, token.Family}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
@@ -102,3 +142,240 @@ func (t *TokenModel) DeleteAllForUser(scope string, userID int64) error {
 	_, err := t.DB.ExecContext(ctx, query, args...)
 	return err
 }
+
+/*
+delete the authentication and refresh tokens of a user except the ones of the
+current login, which is identified by its family or, for tokens issued before
+families existed, by the plaintext of the token itself
+*/
+func (t *TokenModel) DeleteOtherSessions(userID int64, currentPlainText, currentFamily string) error {
+	currentHash := sha256.Sum256([]byte(currentPlainText))
+
+	query := `
+	DELETE FROM tokens
+	WHERE user_id = $1 AND scope IN ($2, $3)
+	AND hash <> $4 AND (family IS NULL OR family <> $5)
+	`
+
+	args := []any{userID, ScopeAuthentication, ScopeRefresh, currentHash[:], currentFamily}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := t.DB.ExecContext(ctx, query, args...)
+	return err
+}
+
+// delete a token using the plaintext the client presented, along with the other tokens of its family
+func (t *TokenModel) DeleteByPlaintext(scope, tokenPlainText string) error {
+	tokenHash := sha256.Sum256([]byte(tokenPlainText))
+
+	query := `
+	DELETE FROM tokens
+	WHERE (hash = $1 AND scope = $2)
+	OR family = (SELECT family FROM tokens WHERE hash = $1 AND scope = $2)
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := t.DB.ExecContext(ctx, query, tokenHash[:], scope)
+	if err != nil {
+		return err
+	}
+
+	//check if any rows affected
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound //no rows affected
+	}
+	return nil
+}
+
+// list the unexpired and unused tokens of a user, the token matching currentPlainText or
+// issued from the login currentFamily is flagged as current
+func (t *TokenModel) GetSessionsForUser(scope string, userID int64, currentPlainText, currentFamily string) ([]*Session, error) {
+	currentHash := sha256.Sum256([]byte(currentPlainText))
+
+	query := `
+	SELECT created_at, expiry, last_used_at, user_agent, COALESCE(hash = $3 OR family = NULLIF($5, ''), false)
+	FROM tokens
+	WHERE scope = $1 AND user_id = $2 AND expiry > $4 AND used_at IS NULL
+	ORDER BY created_at DESC
+	`
+
+	args := []any{scope, userID, currentHash[:], time.Now(), currentFamily}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := t.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []*Session{}
+	for rows.Next() {
+		var session Session
+		var lastUsed sql.NullTime
+		err := rows.Scan(
+			&session.CreatedAt,
+			&session.Expiry,
+			&lastUsed,
+			&session.UserAgent,
+			&session.Current,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if lastUsed.Valid {
+			session.LastUsedAt = &lastUsed.Time
+		}
+		sessions = append(sessions, &session)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+// fetch a token using the plaintext the client presented, used tokens are returned too
+func (t *TokenModel) GetByPlaintext(scope, tokenPlainText string) (*Token, error) {
+	tokenHash := sha256.Sum256([]byte(tokenPlainText))
+
+	query := `
+	SELECT hash, user_id, expiry, scope, user_agent, COALESCE(family, ''), used_at
+	FROM tokens
+	WHERE hash = $1 AND scope = $2
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	token := Token{PlainText: tokenPlainText}
+	var usedAt sql.NullTime
+	err := t.DB.QueryRowContext(ctx, query, tokenHash[:], scope).Scan(
+		&token.Hash,
+		&token.UserID,
+		&token.Expiry,
+		&token.Scope,
+		&token.UserAgent,
+		&token.Family,
+		&usedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	if usedAt.Valid {
+		token.UsedAt = &usedAt.Time
+	}
+	return &token, nil
+}
+
+// mark a single use token as used. If it was used before we get ErrTokenReused,
+// the check and the update happen in one statement so concurrent requests cannot both use it
+func (t *TokenModel) MarkUsed(token *Token) error {
+	query := `
+	UPDATE tokens
+	SET used_at = NOW()
+	WHERE hash = $1 AND used_at IS NULL
+	RETURNING used_at
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var usedAt time.Time
+	err := t.DB.QueryRowContext(ctx, query, token.Hash).Scan(&usedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrTokenReused
+		default:
+			return err
+		}
+	}
+	token.UsedAt = &usedAt
+	return nil
+}
+
+// record that a token was just used, skipped when it was already recorded within LastUsedResolution
+func (t *TokenModel) Touch(scope, tokenPlainText string) error {
+	tokenHash := sha256.Sum256([]byte(tokenPlainText))
+
+	query := `
+	UPDATE tokens
+	SET last_used_at = NOW()
+	WHERE hash = $1
+	AND scope = $2
+	AND (last_used_at IS NULL OR last_used_at < $3)
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := t.DB.ExecContext(ctx, query, tokenHash[:], scope, time.Now().Add(-LastUsedResolution))
+	return err
+}
+
+// delete every token issued from the same login
+func (t *TokenModel) DeleteFamily(family string) error {
+	query := `
+	DELETE FROM tokens
+	WHERE family = $1
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := t.DB.ExecContext(ctx, query, family)
+	return err
+}
+
+// delete the tokens of one scope issued from the same login
+func (t *TokenModel) DeleteFamilyScope(scope, family string) error {
+	query := `
+	DELETE FROM tokens
+	WHERE scope = $1 AND family = $2
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := t.DB.ExecContext(ctx, query, scope, family)
+	return err
+}
+
+// delete at most batchSize tokens that expired before now, returns how many were removed
+func (t *TokenModel) DeleteExpired(now time.Time, batchSize int) (int64, error) {
+	query := `
+	DELETE FROM tokens
+	WHERE hash IN (
+		SELECT hash
+		FROM tokens
+		WHERE expiry < $1
+		LIMIT $2
+	)
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	result, err := t.DB.ExecContext(ctx, query, now, batchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
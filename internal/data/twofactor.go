@@ -0,0 +1,237 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"strings"
+	"time"
+)
+
+// how many recovery codes a user gets when enabling two-factor authentication
+const RecoveryCodeCount = 10
+
+// the TOTP settings of a user
+type TwoFactor struct {
+	Secret   string
+	Enabled  bool
+	LastStep int64 //time step of the last accepted code
+}
+
+// database access
+type TwoFactorModel struct {
+	DB *sql.DB
+}
+
+// new random recovery codes, shown to the user once, formatted like abcde-fghij
+func GenerateRecoveryCodes(n int) ([]string, error) {
+	codes := make([]string, 0, n)
+	for range n {
+		randomBytes := make([]byte, 10)
+		_, err := rand.Read(randomBytes)
+		if err != nil {
+			return nil, err
+		}
+
+		code := strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes))
+		codes = append(codes, code[:5]+"-"+code[5:10]+"-"+code[10:])
+	}
+	return codes, nil
+}
+
+// recovery codes are random enough that a plain sha256 hash is safe to store, like tokens
+func hashRecoveryCode(code string) []byte {
+	hash := sha256.Sum256([]byte(strings.ToLower(strings.TrimSpace(code))))
+	return hash[:]
+}
+
+func (t *TwoFactorModel) Get(userID int64) (*TwoFactor, error) {
+	query := `
+	SELECT COALESCE(totp_secret, ''), totp_enabled, totp_last_step
+	FROM users
+	WHERE id = $1
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var twoFactor TwoFactor
+	err := t.DB.QueryRowContext(ctx, query, userID).Scan(
+		&twoFactor.Secret,
+		&twoFactor.Enabled,
+		&twoFactor.LastStep,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &twoFactor, nil
+}
+
+// start enrollment with a new secret, replacing any earlier unconfirmed one
+func (t *TwoFactorModel) SetPendingSecret(userID int64, secret string) error {
+	query := `
+	UPDATE users
+	SET totp_secret = $1
+	WHERE id = $2 AND NOT totp_enabled
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := t.DB.ExecContext(ctx, query, secret, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrTwoFactorEnabled
+	}
+	return nil
+}
+
+// finish enrollment once the first code was confirmed and store the hashed recovery codes
+func (t *TwoFactorModel) Enable(userID int64, step int64, recoveryCodes []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := t.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	//does nothing once the transaction is committed
+	defer tx.Rollback()
+
+	query := `
+	UPDATE users
+	SET totp_enabled = true, totp_last_step = $1, version = version + 1
+	WHERE id = $2 AND totp_secret IS NOT NULL AND NOT totp_enabled
+	`
+
+	result, err := tx.ExecContext(ctx, query, step, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrTwoFactorEnabled
+	}
+
+	err = insertRecoveryCodes(ctx, tx, userID, recoveryCodes)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// replace the recovery codes of a user
+func insertRecoveryCodes(ctx context.Context, tx *sql.Tx, userID int64, recoveryCodes []string) error {
+	_, err := tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	for _, code := range recoveryCodes {
+		_, err = tx.ExecContext(ctx, `INSERT INTO recovery_codes (hash, user_id) VALUES ($1, $2)`, hashRecoveryCode(code), userID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// accept a code from the given time step, codes from that step or earlier cannot be used again
+func (t *TwoFactorModel) UseStep(userID int64, step int64) error {
+	query := `
+	UPDATE users
+	SET totp_last_step = $1
+	WHERE id = $2 AND totp_last_step < $1
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := t.DB.ExecContext(ctx, query, step, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrCodeReused
+	}
+	return nil
+}
+
+// use up a recovery code, ErrRecordNotFound when it is wrong or was used before
+func (t *TwoFactorModel) UseRecoveryCode(userID int64, code string) error {
+	query := `
+	UPDATE recovery_codes
+	SET used_at = NOW()
+	WHERE hash = $1 AND user_id = $2 AND used_at IS NULL
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := t.DB.ExecContext(ctx, query, hashRecoveryCode(code), userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// turn two-factor authentication off and forget the secret and recovery codes
+func (t *TwoFactorModel) Disable(userID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := t.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+	UPDATE users
+	SET totp_secret = NULL, totp_enabled = false, totp_last_step = 0, version = version + 1
+	WHERE id = $1
+	`
+
+	_, err = tx.ExecContext(ctx, query, userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,30 @@
+package data
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestTwoFactorUseStepRefusesReplay(t *testing.T) {
+	db := newTestDB(t)
+	user := newTestUser(t, db)
+	twoFactor := TwoFactorModel{DB: db}
+
+	err := twoFactor.UseStep(user.ID, 100)
+	if err != nil {
+		t.Fatalf("first use: %v", err)
+	}
+
+	//the same code again, and an older one that is still inside the skew window
+	for _, step := range []int64{100, 99} {
+		err = twoFactor.UseStep(user.ID, step)
+		if !errors.Is(err, ErrCodeReused) {
+			t.Errorf("step %d: got %v; want ErrCodeReused", step, err)
+		}
+	}
+
+	err = twoFactor.UseStep(user.ID, 101)
+	if err != nil {
+		t.Errorf("next step: %v", err)
+	}
+}
@@ -4,24 +4,51 @@ import (
 	"context"
 	"crypto/sha256"
 	"database/sql"
+	"encoding/json"
 	"errors"
+	"net/url"
 	"time"
 
 	"github.com/abner-tech/Test3-Api.git/internal/validator"
-	"golang.org/x/crypto/bcrypt"
+	"github.com/lib/pq"
 )
 
 var AnonymouseUser = &User{}
 
 // user type declaration
 type User struct {
+	ID          int64           `json:"id"`
+	Created_At  time.Time       `json:"created_at"`
+	Username    string          `json:"username"`
+	Email       string          `json:"email"`
+	Password    password        `json:"-"`
+	Activated   bool            `json:"activated"`
+	Bio         string          `json:"bio"`
+	AvatarURL   string          `json:"avatar_url"`
+	Preferences json.RawMessage `json:"preferences"`
+	LockedUntil *time.Time      `json:"-"` //logins are refused until then after too many failures
+	TwoFactor   bool            `json:"two_factor_enabled"`
+	Version     int             `json:"-"`
+}
+
+// what other users get to see of a user, never includes the email address
+type PublicUser struct {
 	ID         int64     `json:"id"`
 	Created_At time.Time `json:"created_at"`
 	Username   string    `json:"username"`
-	Email      string    `json:"email"`
-	Password   password  `json:"-"`
-	Activated  bool      `json:"activated"`
-	Version    int       `json:"-"`
+	Bio        string    `json:"bio"`
+	AvatarURL  string    `json:"avatar_url"`
+}
+
+// the public projection of the user
+func (u *User) Public() *PublicUser {
+	return &PublicUser{
+		ID:         u.ID,
+		Created_At: u.Created_At,
+		Username:   u.Username,
+		Bio:        u.Bio,
+		AvatarURL:  u.AvatarURL,
+	}
 }
 
 type password struct {
@@ -31,7 +58,8 @@ type password struct {
 
 // struct setup for our model
 type UserModel struct {
-	DB *sql.DB
+	DB      *sql.DB
+	Hashing PasswordHashParams //how new password hashes are made
 }
 
 // insert new user to the database
@@ -63,7 +91,7 @@ func (u *UserModel) Insert(user *User) error {
 // get a user from the database based on their email provided
 func (u *UserModel) GetByEmail(email string) (*User, error) {
 	query := `
-	SELECT id, created_at, username, email, password_hash, activated, version
+	SELECT id, created_at, username, email, password_hash, activated, bio, avatar_url, preferences, locked_until, totp_enabled, version
 	FROM users
 	WHERE email = $1
 	`
@@ -78,6 +106,11 @@ func (u *UserModel) GetByEmail(email string) (*User, error) {
 		&user.Email,
 		&user.Password.hash,
 		&user.Activated,
+		&user.Bio,
+		&user.AvatarURL,
+		(*[]byte)(&user.Preferences),
+		&user.LockedUntil,
+		&user.TwoFactor,
 		&user.Version,
 	)
 	if err != nil {
@@ -95,7 +128,7 @@ func (u *UserModel) GetByEmail(email string) (*User, error) {
 // get the user by the provided id
 func (u *UserModel) GetByID(id int64) (*User, error) {
 	query := `
-	SELECT id, created_at, username, email, activated, version
+	SELECT id, created_at, username, email, password_hash, activated, bio, avatar_url, preferences, locked_until, totp_enabled, version
 	FROM users
 	WHERE id = $1
 	`
@@ -108,7 +141,13 @@ func (u *UserModel) GetByID(id int64) (*User, error) {
 		&user.Created_At,
 		&user.Username,
 		&user.Email,
+		&user.Password.hash,
 		&user.Activated,
+		&user.Bio,
+		&user.AvatarURL,
+		(*[]byte)(&user.Preferences),
+		&user.LockedUntil,
+		&user.TwoFactor,
 		&user.Version,
 	)
 	if err != nil {
@@ -151,44 +190,58 @@ func (u *UserModel) GetUserReadingLists(id int64) (*Reading_List, error) {
 }
 
 func (u *UserModel) GetForToken(tokenScope, tokenPlainText string) (*User, error) {
+	user, _, err := u.GetForTokenWithExpiry(tokenScope, tokenPlainText)
+	return user, err
+}
+
+// the user behind a token together with the time the token expires, so callers caching the result can stop in time
+func (u *UserModel) GetForTokenWithExpiry(tokenScope, tokenPlainText string) (*User, time.Time, error) {
 	tokenHash := sha256.Sum256([]byte(tokenPlainText))
 
 	query := `
-	SELECT users.id, users.created_at, users.username, users.email, users.password_hash, users.activated, users.version
+	SELECT tokens.expiry, users.id, users.created_at, users.username, users.email, users.password_hash, users.activated,
+	users.bio, users.avatar_url, users.preferences, users.locked_until, users.totp_enabled, users.version
 	FROM users
 	INNER JOIN tokens
 	ON users.id = tokens.user_id
 	WHERE tokens.hash = $1
 	AND tokens.scope = $2
-	and tokens.expiry > $3
+	AND tokens.expiry > $3
 	`
 
 	args := []any{tokenHash[:], tokenScope, time.Now()}
 
 	var user User
+	var expiry time.Time
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 	err := u.DB.QueryRowContext(ctx, query, args...).Scan(
+		&expiry,
 		&user.ID,
 		&user.Created_At,
 		&user.Username,
 		&user.Email,
 		&user.Password.hash,
 		&user.Activated,
+		&user.Bio,
+		&user.AvatarURL,
+		(*[]byte)(&user.Preferences),
+		&user.LockedUntil,
+		&user.TwoFactor,
 		&user.Version,
 	)
 
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
-			return nil, ErrRecordNotFound
+			return nil, time.Time{}, ErrRecordNotFound
 		default:
-			return nil, err
+			return nil, time.Time{}, err
 		}
 	}
 	//return the correct user
-	return &user, nil
+	return &user, expiry, nil
 }
 
 /*
@@ -200,12 +253,20 @@ func (u *UserModel) Update(user *User) error {
 	query := `
 	UPDATE users
 	SET username = $1, email =$2, password_hash = $3, activated = $4,
+	bio = $5, avatar_url = $6, preferences = $7,
 	version = version + 1
-	WHERE id = $5 AND version = $6
+	WHERE id = $8 AND version = $9
 	RETURNING version
 	`
 
-	args := []any{user.Username, user.Email, user.Password.hash, user.Activated, user.ID, user.Version}
+	//preferences are always stored as a json object
+	preferences := user.Preferences
+	if len(preferences) == 0 {
+		preferences = json.RawMessage("{}")
+	}
+
+	args := []any{user.Username, user.Email, user.Password.hash, user.Activated,
+		user.Bio, user.AvatarURL, []byte(preferences), user.ID, user.Version}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
@@ -214,7 +275,7 @@ func (u *UserModel) Update(user *User) error {
 	//check for errors during an update
 	if err != nil {
 		switch {
-		case err.Error() == `pq: duplicate key value violates unique key constraints "users_email_key"`:
+		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
 			return ErrDuplicateEmail
 		case errors.Is(err, sql.ErrNoRows):
 			return ErrEditConfilct
@@ -225,32 +286,302 @@ func (u *UserModel) Update(user *User) error {
 	return nil
 }
 
-// the set method computes the hash of the password
-func (p *password) Set(plainTextPassword string) error {
-	hash, err := bcrypt.GenerateFromPassword([]byte(plainTextPassword), 12)
+// remember the address the user wants to switch to until they confirm it
+func (u *UserModel) SetPendingEmail(userID int64, email string) error {
+	query := `
+	UPDATE users
+	SET pending_email = $1
+	WHERE id = $2
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := u.DB.ExecContext(ctx, query, email, userID)
 	if err != nil {
 		return err
 	}
 
-	p.plaintext = &plainTextPassword
-	p.hash = hash
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
 
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
 	return nil
 }
 
-// compare if client-provided plain-text password matches saved hashed-password version
-func (p *password) Matches(plaintextPassword string) (bool, error) {
-	err := bcrypt.CompareHashAndPassword(p.hash, []byte(plaintextPassword))
+/*
+swap the confirmed pending address into users.email. Someone may have
+registered the address after the change was requested, in which case we
+get ErrDuplicateEmail and the pending address is kept so the user can see why
+*/
+func (u *UserModel) ConfirmPendingEmail(user *User) error {
+	query := `
+	UPDATE users
+	SET email = pending_email, pending_email = NULL, version = version + 1
+	WHERE id = $1 AND pending_email IS NOT NULL
+	RETURNING email, version
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := u.DB.QueryRowContext(ctx, query, user.ID).Scan(&user.Email, &user.Version)
 	if err != nil {
 		switch {
-		case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword):
-			return false, nil
+		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
+			return ErrDuplicateEmail
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// count a failed login, returns the number of failures since the last successful login
+func (u *UserModel) RecordFailedLogin(userID int64) (int, error) {
+	query := `
+	UPDATE users
+	SET failed_logins = failed_logins + 1
+	WHERE id = $1
+	RETURNING failed_logins
+	`
 
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var failures int
+	err := u.DB.QueryRowContext(ctx, query, userID).Scan(&failures)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, ErrRecordNotFound
 		default:
-			return false, nil
+			return 0, err
+		}
+	}
+	return failures, nil
+}
+
+// refuse logins until the given time, a lockout also starts counting failures from zero again
+func (u *UserModel) LockUntil(userID int64, until time.Time, resetFailures bool) error {
+	query := `
+	UPDATE users
+	SET locked_until = $1,
+	failed_logins = CASE WHEN $2 THEN 0 ELSE failed_logins END
+	WHERE id = $3
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := u.DB.ExecContext(ctx, query, until, resetFailures, userID)
+	return err
+}
+
+// forget failed logins after a successful one
+func (u *UserModel) ResetFailedLogins(userID int64) error {
+	query := `
+	UPDATE users
+	SET failed_logins = 0, locked_until = NULL
+	WHERE id = $1 AND (failed_logins > 0 OR locked_until IS NOT NULL)
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := u.DB.ExecContext(ctx, query, userID)
+	return err
+}
+
+// hash a new password for the user with the hashing settings of the model
+func (u *UserModel) SetPassword(user *User, plainTextPassword string) error {
+	return user.Password.set(plainTextPassword, u.Hashing)
+}
+
+// report if the stored hash of the user should be recomputed because the hashing settings changed since it was made
+func (u *UserModel) NeedsRehash(user *User) bool {
+	return u.Hashing.outdated(user.Password.hash)
+}
+
+/*
+replace an outdated password hash after the plaintext was verified. The version
+is not bumped since the password itself did not change, and the hash is only
+replaced if nobody changed the password in the meantime
+*/
+func (u *UserModel) RehashPassword(user *User, plainTextPassword string) error {
+	oldHash := user.Password.hash
+
+	err := u.SetPassword(user, plainTextPassword)
+	if err != nil {
+		return err
+	}
+
+	query := `
+	UPDATE users
+	SET password_hash = $1
+	WHERE id = $2 AND password_hash = $3
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err = u.DB.ExecContext(ctx, query, user.Password.hash, user.ID, oldHash)
+	return err
+}
+
+// schedule the account to be deleted once the grace period is over
+func (u *UserModel) ScheduleDeletion(userID int64, at time.Time) error {
+	query := `
+	UPDATE users
+	SET deletion_scheduled_at = $1, version = version + 1
+	WHERE id = $2
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := u.DB.ExecContext(ctx, query, at, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// cancel a pending deletion, reports whether one was pending
+func (u *UserModel) CancelDeletion(userID int64) (bool, error) {
+	query := `
+	UPDATE users
+	SET deletion_scheduled_at = NULL, version = version + 1
+	WHERE id = $1 AND deletion_scheduled_at IS NOT NULL
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := u.DB.ExecContext(ctx, query, userID)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rowsAffected > 0, nil
+}
+
+/*
+delete at most batchSize accounts whose grace period ended before now, returns
+how many were removed. Their tokens, permissions, roles, reading lists,
+reviews and helpful votes go with them through the ON DELETE CASCADE foreign
+keys, so the helpful counts and book ratings they counted towards are fixed
+in the same transaction
+*/
+func (u *UserModel) DeleteScheduled(now time.Time, batchSize int) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tx, err := u.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var ids []int64
+	err = tx.QueryRowContext(ctx, `
+	SELECT COALESCE(array_agg(id), '{}')
+	FROM (SELECT id FROM users WHERE deletion_scheduled_at < $1 LIMIT $2) AS doomed
+	`, now, batchSize).Scan(pq.Array(&ids))
+	if err != nil {
+		return 0, err
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	//their reviews go with them, lock the books first like every other review change does
+	var books []int64
+	err = tx.QueryRowContext(ctx, `
+	SELECT COALESCE(array_agg(id ORDER BY id), '{}')
+	FROM (
+		SELECT id FROM books
+		WHERE id IN (SELECT book_id FROM reviews WHERE user_id = ANY($1))
+		ORDER BY id
+		FOR UPDATE
+	) AS reviewed
+	`, pq.Array(ids)).Scan(pq.Array(&books))
+	if err != nil {
+		return 0, err
+	}
+
+	//and so do their helpful votes, which must come off the counters
+	_, err = tx.ExecContext(ctx, `
+	UPDATE reviews
+	SET helpful_count = reviews.helpful_count - votes.total
+	FROM (
+		SELECT review_id, COUNT(*) AS total
+		FROM review_helpful_votes
+		WHERE user_id = ANY($1)
+		GROUP BY review_id
+	) AS votes
+	WHERE reviews.id = votes.review_id
+	`, pq.Array(ids))
+	if err != nil {
+		return 0, err
+	}
+
+	result, err := tx.ExecContext(ctx, `
+	DELETE FROM users
+	WHERE id = ANY($1) AND deletion_scheduled_at < $2
+	`, pq.Array(ids), now)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, bookID := range books {
+		_, err = updateBookRating(ctx, tx, bookID)
+		if err != nil {
+			return 0, err
 		}
 	}
-	return true, nil //when password is correct
+
+	removed, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return removed, tx.Commit()
+}
+
+// the set method computes the hash of the password with the given settings
+func (p *password) set(plainTextPassword string, params PasswordHashParams) error {
+	hash, err := params.hash(plainTextPassword)
+	if err != nil {
+		return err
+	}
+
+	p.plaintext = &plainTextPassword
+	p.hash = hash
+
+	return nil
+}
+
+// compare if client-provided plain-text password matches saved hashed-password version
+func (p *password) Matches(plaintextPassword string) (bool, error) {
+	return compareHashAndPassword(p.hash, plaintextPassword)
 }
 
 // validation for the email address
@@ -259,18 +590,49 @@ func ValidateEmail(v *validator.Validator, email string) {
 	v.Check(validator.Matches(email, validator.EmailRX), "email", "must be a valid email address")
 }
 
-// check for password to be valid
+// check the password has a usable shape, new passwords also go through the PasswordPolicy
 func ValidatePassword(v *validator.Validator, password string) {
 	v.Check(password != "", "password", "must be provided")
-	v.Check(len(password) >= 7, "password", "must be at least 8 bytes long")
-	v.Check(len(password) <= 72, "password", "mustnot be more than 72 bytes long")
+	v.Check(len(password) <= 72, "password", "must not be more than 72 bytes long")
 }
 
-// validate username
-func ValidateUser(v *validator.Validator, user *User) {
+// the avatar is optional, but when given it must be an absolute http(s) url
+func ValidateAvatarURL(v *validator.Validator, avatarURL string) {
+	if avatarURL == "" {
+		return
+	}
+	v.Check(len(avatarURL) <= 2048, "avatar_url", "must not be more than 2048 bytes long")
+
+	parsed, err := url.Parse(avatarURL)
+	v.Check(err == nil && (parsed.Scheme == "http" || parsed.Scheme == "https") && parsed.Host != "",
+		"avatar_url", "must be a valid http or https url")
+}
+
+// preferences are free-form but must be a json object of reasonable size
+func ValidatePreferences(v *validator.Validator, preferences json.RawMessage) {
+	if len(preferences) == 0 {
+		return
+	}
+	v.Check(len(preferences) <= 4096, "preferences", "must not be more than 4096 bytes long")
+
+	var object map[string]any
+	v.Check(json.Unmarshal(preferences, &object) == nil && object != nil, "preferences", "must be a json object")
+}
+
+// the fields a user can change on their own profile
+func ValidateProfile(v *validator.Validator, user *User) {
 	v.Check(user.Username != "", "username", "must be provided")
 	v.Check(len(user.Username) <= 200, "username", "must not be more than 200 bytes long")
 
+	v.Check(len(user.Bio) <= 500, "bio", "must not be more than 500 bytes long")
+	ValidateAvatarURL(v, user.AvatarURL)
+	ValidatePreferences(v, user.Preferences)
+}
+
+// validate username
+func ValidateUser(v *validator.Validator, user *User) {
+	ValidateProfile(v, user)
+
 	//validate user for email
 	ValidateEmail(v, user.Email)
 	//validate the plain text email
@@ -0,0 +1,209 @@
+package jwt
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+/*
+Signed access tokens (JWT) so authenticated requests can be verified without
+going to the database. Tokens are signed with Ed25519 (alg EdDSA) and carry
+the id of the key used in the 'kid' header, which lets us rotate keys: publish
+the new public key to every server, switch the signing key, and remove the old
+public key once the tokens signed with it have expired
+*/
+
+var ErrInvalidToken = errors.New("invalid signed token")
+var ErrExpiredToken = errors.New("signed token has expired")
+var ErrUnknownKey = errors.New("signed token uses an unknown key")
+
+// what we put inside a token
+type Claims struct {
+	Subject     int64    `json:"sub"`
+	Activated   bool     `json:"act"`
+	Permissions []string `json:"perms"`
+	Family      string   `json:"fam,omitempty"` //the login the token was issued from
+	IssuedAt    int64    `json:"iat"`
+	Expiry      int64    `json:"exp"`
+}
+
+type header struct {
+	Algorithm string `json:"alg"`
+	Type      string `json:"typ"`
+	KeyID     string `json:"kid"`
+}
+
+// signs new tokens with the current private key
+type Signer struct {
+	keyID string
+	key   ed25519.PrivateKey
+}
+
+// checks tokens against every public key that is still accepted
+type Verifier struct {
+	keys map[string]ed25519.PublicKey
+}
+
+var encoding = base64.RawURLEncoding
+
+// load the PEM encoded (PKCS8) Ed25519 private key used for signing
+func LoadSigner(path, keyID string) (*Signer, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("signing key %s: %w", path, err)
+	}
+
+	privateKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("signing key %s: not an ed25519 key", path)
+	}
+
+	return &Signer{keyID: keyID, key: privateKey}, nil
+}
+
+/*
+load every PEM encoded (PKIX) Ed25519 public key in a directory. The file
+name without its extension is the key id, so 2024-11.pem verifies tokens
+with kid 2024-11
+*/
+func LoadVerifier(dir string) (*Verifier, error) {
+	verifier := &Verifier{keys: make(map[string]ed25519.PublicKey)}
+	if dir == "" {
+		return verifier, nil
+	}
+
+	paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, path := range paths {
+		block, err := readPEM(path)
+		if err != nil {
+			return nil, err
+		}
+
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("verification key %s: %w", path, err)
+		}
+
+		publicKey, ok := key.(ed25519.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("verification key %s: not an ed25519 key", path)
+		}
+
+		keyID := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+		verifier.keys[keyID] = publicKey
+	}
+	return verifier, nil
+}
+
+func readPEM(path string) (*pem.Block, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(contents)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM data found", path)
+	}
+	return block, nil
+}
+
+// make sure tokens we sign ourselves can always be verified
+func (v *Verifier) Trust(s *Signer) {
+	v.keys[s.keyID] = s.key.Public().(ed25519.PublicKey)
+}
+
+// create a token for the claims, IssuedAt and Expiry are filled in from ttl
+func (s *Signer) Sign(claims Claims, ttl time.Duration) (string, time.Time, error) {
+	now := time.Now()
+	expiry := now.Add(ttl)
+	claims.IssuedAt = now.Unix()
+	claims.Expiry = expiry.Unix()
+
+	headerJSON, err := json.Marshal(header{Algorithm: "EdDSA", Type: "JWT", KeyID: s.keyID})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	claimsJSON, err := json.Marshal(claims)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	signingInput := encoding.EncodeToString(headerJSON) + "." + encoding.EncodeToString(claimsJSON)
+	signature := ed25519.Sign(s.key, []byte(signingInput))
+
+	return signingInput + "." + encoding.EncodeToString(signature), expiry, nil
+}
+
+// check the signature and expiry of a token and return its claims
+func (v *Verifier) Verify(token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidToken
+	}
+
+	headerJSON, err := encoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	var h header
+	err = json.Unmarshal(headerJSON, &h)
+	if err != nil || h.Algorithm != "EdDSA" {
+		return nil, ErrInvalidToken
+	}
+
+	key, found := v.keys[h.KeyID]
+	if !found {
+		return nil, ErrUnknownKey
+	}
+
+	signature, err := encoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	if !ed25519.Verify(key, []byte(parts[0]+"."+parts[1]), signature) {
+		return nil, ErrInvalidToken
+	}
+
+	claimsJSON, err := encoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	var claims Claims
+	err = json.Unmarshal(claimsJSON, &claims)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	if time.Now().Unix() >= claims.Expiry {
+		return nil, ErrExpiredToken
+	}
+	return &claims, nil
+}
+
+// signed tokens have three dot separated parts, opaque tokens have none
+func LooksSigned(token string) bool {
+	return strings.Count(token, ".") == 2
+}
@@ -0,0 +1,184 @@
+package jwt
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// a signer with a fresh key, and its public key written to dir as <keyID>.pem when dir is not empty
+func newTestSigner(t *testing.T, keyID, dir string) *Signer {
+	t.Helper()
+
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if dir != "" {
+		writePublicKey(t, filepath.Join(dir, keyID+".pem"), publicKey)
+	}
+	return &Signer{keyID: keyID, key: privateKey}
+}
+
+func writePublicKey(t *testing.T, path string, key any) {
+	t.Helper()
+
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSignVerifyRoundTrip(t *testing.T) {
+	signer := newTestSigner(t, "current", "")
+	verifier := &Verifier{keys: map[string]ed25519.PublicKey{}}
+	verifier.Trust(signer)
+
+	claims := Claims{Subject: 42, Activated: true, Permissions: []string{"books:read"}, Family: "login"}
+	token, expiry, err := signer.Sign(claims, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !LooksSigned(token) {
+		t.Errorf("LooksSigned(%q) = false", token)
+	}
+
+	got, err := verifier.Verify(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Subject != 42 || !got.Activated || got.Family != "login" || !slices.Equal(got.Permissions, claims.Permissions) {
+		t.Errorf("got claims %+v; want %+v", got, claims)
+	}
+	if got.Expiry != expiry.Unix() {
+		t.Errorf("got expiry %d; want %d", got.Expiry, expiry.Unix())
+	}
+}
+
+func TestVerifyRejects(t *testing.T) {
+	signer := newTestSigner(t, "current", "")
+	verifier := &Verifier{keys: map[string]ed25519.PublicKey{}}
+	verifier.Trust(signer)
+
+	valid, _, err := signer.Sign(Claims{Subject: 1}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(valid, ".")
+
+	expired, _, err := signer.Sign(Claims{Subject: 1}, -time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	unknown, _, err := newTestSigner(t, "other", "").Sign(Claims{Subject: 1}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//same payload but claiming more permissions, the signature no longer matches
+	tamperedClaims, err := json.Marshal(Claims{Subject: 1, Permissions: []string{"users:write"}, Expiry: time.Now().Add(time.Hour).Unix()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tamperedPayload := parts[0] + "." + encoding.EncodeToString(tamperedClaims) + "." + parts[2]
+
+	signature, err := encoding.DecodeString(parts[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature[0] ^= 0xff
+	tamperedSignature := parts[0] + "." + parts[1] + "." + encoding.EncodeToString(signature)
+
+	//a header asking for a different algorithm is refused even with a valid signature
+	noneHeader, err := json.Marshal(header{Algorithm: "none", Type: "JWT", KeyID: "current"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrongAlgInput := encoding.EncodeToString(noneHeader) + "." + parts[1]
+	wrongAlg := wrongAlgInput + "." + encoding.EncodeToString(ed25519.Sign(signer.key, []byte(wrongAlgInput)))
+
+	tests := []struct {
+		name  string
+		token string
+		want  error
+	}{
+		{"expired", expired, ErrExpiredToken},
+		{"unknown key", unknown, ErrUnknownKey},
+		{"tampered payload", tamperedPayload, ErrInvalidToken},
+		{"tampered signature", tamperedSignature, ErrInvalidToken},
+		{"wrong alg", wrongAlg, ErrInvalidToken},
+		{"not a jwt", "opaquetoken", ErrInvalidToken},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := verifier.Verify(tt.token)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("got %v; want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	dir := t.TempDir()
+	oldSigner := newTestSigner(t, "2024-11", dir)
+
+	oldToken, _, err := oldSigner.Sign(Claims{Subject: 1}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//the new key is only trusted through the signer, the old one only through the directory
+	verifier, err := LoadVerifier(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newSigner := newTestSigner(t, "2024-12", "")
+	verifier.Trust(newSigner)
+
+	newToken, _, err := newSigner.Sign(Claims{Subject: 1}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, token := range map[string]string{"old key": oldToken, "new key": newToken} {
+		_, err := verifier.Verify(token)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
+
+func TestLoadVerifierRejectsOtherKeyTypes(t *testing.T) {
+	dir := t.TempDir()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePublicKey(t, filepath.Join(dir, "ecdsa.pem"), &key.PublicKey)
+
+	_, err = LoadVerifier(dir)
+	if err == nil || !strings.Contains(err.Error(), "not an ed25519 key") {
+		t.Errorf("got %v; want a not an ed25519 key error", err)
+	}
+}
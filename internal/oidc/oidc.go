@@ -0,0 +1,412 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+OpenID Connect login with the authorization code flow and PKCE. A provider is
+found through its discovery document, the ID token returned by the token
+endpoint is checked against the provider's published keys (RS256)
+*/
+
+var ErrInvalidIDToken = errors.New("invalid id token")
+
+// settings for one provider, usually given on the command line
+type Config struct {
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+}
+
+// what we learn about the user from the provider
+type Claims struct {
+	Subject       string `json:"sub"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	Name          string `json:"name"`
+}
+
+// the endpoints from the discovery document
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserinfoEndpoint      string `json:"userinfo_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type Provider struct {
+	Config
+	client *http.Client
+
+	mu        sync.Mutex
+	endpoints *discovery
+	keys      map[string]*rsa.PublicKey
+}
+
+func NewProvider(config Config) *Provider {
+	if len(config.Scopes) == 0 {
+		config.Scopes = []string{"openid", "email", "profile"}
+	}
+	return &Provider{
+		Config: config,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// a random url safe string, used for state, nonce and the PKCE verifier
+func RandomString() (string, error) {
+	randomBytes := make([]byte, 32)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(randomBytes), nil
+}
+
+// the S256 PKCE challenge for a verifier
+func challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// fetch the discovery document once, it is cached after that
+func (p *Provider) discover(ctx context.Context) (*discovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.endpoints != nil {
+		return p.endpoints, nil
+	}
+
+	var endpoints discovery
+	err := p.getJSON(ctx, strings.TrimSuffix(p.Issuer, "/")+"/.well-known/openid-configuration", "", &endpoints)
+	if err != nil {
+		return nil, err
+	}
+	if endpoints.Issuer != p.Issuer {
+		return nil, fmt.Errorf("oidc %s: discovery issuer %q does not match %q", p.Name, endpoints.Issuer, p.Issuer)
+	}
+
+	p.endpoints = &endpoints
+	return p.endpoints, nil
+}
+
+// where to send the user to log in with the provider
+func (p *Provider) AuthCodeURL(ctx context.Context, state, nonce, verifier string) (string, error) {
+	endpoints, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	query := url.Values{}
+	query.Set("response_type", "code")
+	query.Set("client_id", p.ClientID)
+	query.Set("redirect_uri", p.RedirectURL)
+	query.Set("scope", strings.Join(p.Scopes, " "))
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", challenge(verifier))
+	query.Set("code_challenge_method", "S256")
+
+	separator := "?"
+	if strings.Contains(endpoints.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return endpoints.AuthorizationEndpoint + separator + query.Encode(), nil
+}
+
+/*
+exchange the authorization code for tokens and return the verified claims of
+the ID token. The nonce must be the one sent with the authorization request
+*/
+func (p *Provider) Exchange(ctx context.Context, code, verifier, nonce string) (*Claims, error) {
+	endpoints, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.RedirectURL)
+	form.Set("client_id", p.ClientID)
+	form.Set("client_secret", p.ClientSecret)
+	form.Set("code_verifier", verifier)
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoints.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Accept", "application/json")
+
+	var tokens struct {
+		AccessToken string `json:"access_token"`
+		IDToken     string `json:"id_token"`
+	}
+	err = p.doJSON(request, &tokens)
+	if err != nil {
+		return nil, err
+	}
+	if tokens.IDToken == "" {
+		return nil, fmt.Errorf("oidc %s: no id token in the token response", p.Name)
+	}
+
+	claims, err := p.verify(ctx, tokens.IDToken, nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	//some providers leave the email out of the ID token
+	if claims.Email == "" && endpoints.UserinfoEndpoint != "" && tokens.AccessToken != "" {
+		var userinfo Claims
+		err = p.getJSON(ctx, endpoints.UserinfoEndpoint, tokens.AccessToken, &userinfo)
+		if err != nil {
+			return nil, err
+		}
+		if userinfo.Subject == claims.Subject {
+			claims.Email = userinfo.Email
+			claims.EmailVerified = userinfo.EmailVerified
+			if claims.Name == "" {
+				claims.Name = userinfo.Name
+			}
+		}
+	}
+	return claims, nil
+}
+
+// check the signature, issuer, audience, expiry and nonce of an ID token
+func (p *Provider) verify(ctx context.Context, idToken, nonce string) (*Claims, error) {
+	parts := strings.Split(idToken, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidIDToken
+	}
+
+	headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrInvalidIDToken
+	}
+
+	var header struct {
+		Algorithm string `json:"alg"`
+		KeyID     string `json:"kid"`
+	}
+	err = json.Unmarshal(headerJSON, &header)
+	if err != nil || header.Algorithm != "RS256" {
+		return nil, ErrInvalidIDToken
+	}
+
+	key, err := p.key(ctx, header.KeyID)
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidIDToken
+	}
+
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	err = rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature)
+	if err != nil {
+		return nil, ErrInvalidIDToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrInvalidIDToken
+	}
+
+	var raw struct {
+		Issuer        string          `json:"iss"`
+		Audience      json.RawMessage `json:"aud"`
+		Expiry        int64           `json:"exp"`
+		Nonce         string          `json:"nonce"`
+		Subject       string          `json:"sub"`
+		Email         string          `json:"email"`
+		EmailVerified any             `json:"email_verified"`
+		Name          string          `json:"name"`
+	}
+	err = json.Unmarshal(payload, &raw)
+	if err != nil {
+		return nil, ErrInvalidIDToken
+	}
+
+	if raw.Issuer != p.Issuer || !hasAudience(raw.Audience, p.ClientID) || raw.Subject == "" {
+		return nil, ErrInvalidIDToken
+	}
+	if time.Now().Unix() >= raw.Expiry {
+		return nil, ErrInvalidIDToken
+	}
+	if subtle.ConstantTimeCompare([]byte(raw.Nonce), []byte(nonce)) != 1 {
+		return nil, ErrInvalidIDToken
+	}
+
+	return &Claims{
+		Subject: raw.Subject,
+		Email:   raw.Email,
+		//a few providers send the flag as a string
+		EmailVerified: raw.EmailVerified == true || raw.EmailVerified == "true",
+		Name:          raw.Name,
+	}, nil
+}
+
+// the audience is either a single client id or a list of them
+func hasAudience(audience json.RawMessage, clientID string) bool {
+	var single string
+	if json.Unmarshal(audience, &single) == nil {
+		return single == clientID
+	}
+
+	var many []string
+	if json.Unmarshal(audience, &many) == nil {
+		for _, aud := range many {
+			if aud == clientID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// the public key with the given id, the key set is fetched again when the id is unknown (key rotation)
+func (p *Provider) key(ctx context.Context, keyID string) (*rsa.PublicKey, error) {
+	p.mu.Lock()
+	key, found := p.keys[keyID]
+	p.mu.Unlock()
+	if found {
+		return key, nil
+	}
+
+	endpoints, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var set struct {
+		Keys []struct {
+			KeyType string `json:"kty"`
+			KeyID   string `json:"kid"`
+			N       string `json:"n"`
+			E       string `json:"e"`
+		} `json:"keys"`
+	}
+	err = p.getJSON(ctx, endpoints.JWKSURI, "", &set)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+	for _, jwk := range set.Keys {
+		if jwk.KeyType != "RSA" {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+		if err != nil {
+			continue
+		}
+		e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+		if err != nil || len(e) > 4 {
+			continue
+		}
+		keys[jwk.KeyID] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+
+	p.mu.Lock()
+	p.keys = keys
+	p.mu.Unlock()
+
+	key, found = keys[keyID]
+	if !found {
+		return nil, ErrInvalidIDToken
+	}
+	return key, nil
+}
+
+func (p *Provider) getJSON(ctx context.Context, url, accessToken string, destination any) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Accept", "application/json")
+	if accessToken != "" {
+		request.Header.Set("Authorization", "Bearer "+accessToken)
+	}
+	return p.doJSON(request, destination)
+}
+
+func (p *Provider) doJSON(request *http.Request, destination any) error {
+	response, err := p.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(response.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("oidc %s: %s returned %s", p.Name, request.URL.Path, response.Status)
+	}
+	return json.Unmarshal(body, destination)
+}
+
+/*
+parse a provider from the command line, a comma separated list of settings:
+name=google,issuer=https://accounts.google.com,client-id=ID,client-secret=SECRET
+and optionally scopes=openid email profile
+*/
+func ParseConfig(value string) (Config, error) {
+	var config Config
+	for _, setting := range strings.Split(value, ",") {
+		key, val, found := strings.Cut(strings.TrimSpace(setting), "=")
+		if !found {
+			return config, fmt.Errorf("oidc provider: %q is not key=value", setting)
+		}
+
+		switch key {
+		case "name":
+			config.Name = val
+		case "issuer":
+			config.Issuer = val
+		case "client-id":
+			config.ClientID = val
+		case "client-secret":
+			config.ClientSecret = val
+		case "scopes":
+			config.Scopes = strings.Fields(val)
+		default:
+			return config, fmt.Errorf("oidc provider: unknown setting %q", key)
+		}
+	}
+
+	if config.Name == "" || config.Issuer == "" || config.ClientID == "" {
+		return config, errors.New("oidc provider: name, issuer and client-id are required")
+	}
+	return config, nil
+}
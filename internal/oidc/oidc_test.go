@@ -0,0 +1,217 @@
+package oidc_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/abner-tech/Test3-Api.git/internal/oidc"
+	"github.com/abner-tech/Test3-Api.git/internal/oidc/oidctest"
+)
+
+const redirectURL = "http://localhost:4000/api/v1/oidc/fake/callback"
+
+func newFakeProvider(t *testing.T) (*oidctest.Server, *oidc.Provider) {
+	t.Helper()
+
+	server, err := oidctest.NewServer("client", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(server.Close)
+
+	provider := oidc.NewProvider(oidc.Config{
+		Name:         "fake",
+		Issuer:       server.Issuer(),
+		ClientID:     "client",
+		ClientSecret: "secret",
+		RedirectURL:  redirectURL,
+	})
+	return server, provider
+}
+
+// a login as the client starts it: fresh state, nonce and verifier, and the url for the user
+type login struct {
+	state, nonce, verifier string
+	authorizationURL       string
+}
+
+func startLogin(t *testing.T, provider *oidc.Provider) login {
+	t.Helper()
+
+	var l login
+	for _, value := range []*string{&l.state, &l.nonce, &l.verifier} {
+		random, err := oidc.RandomString()
+		if err != nil {
+			t.Fatal(err)
+		}
+		*value = random
+	}
+
+	authorizationURL, err := provider.AuthCodeURL(context.Background(), l.state, l.nonce, l.verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.authorizationURL = authorizationURL
+	return l
+}
+
+func TestLoginRoundTrip(t *testing.T) {
+	server, provider := newFakeProvider(t)
+	l := startLogin(t, provider)
+
+	callback, err := server.Authorize(l.authorizationURL, oidctest.Login{
+		Subject:       "248289761001",
+		Email:         "jane@example.com",
+		EmailVerified: true,
+		Name:          "Jane",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := callback.Scheme + "://" + callback.Host + callback.Path; got != redirectURL {
+		t.Errorf("sent back to %q; want %q", got, redirectURL)
+	}
+	if got := callback.Query().Get("state"); got != l.state {
+		t.Errorf("got state %q; want %q", got, l.state)
+	}
+
+	claims, err := provider.Exchange(context.Background(), callback.Query().Get("code"), l.verifier, l.nonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := oidc.Claims{Subject: "248289761001", Email: "jane@example.com", EmailVerified: true, Name: "Jane"}
+	if *claims != want {
+		t.Errorf("got claims %+v; want %+v", *claims, want)
+	}
+}
+
+func TestExchangeRefusesWrongVerifierAndReusedCode(t *testing.T) {
+	server, provider := newFakeProvider(t)
+	l := startLogin(t, provider)
+
+	callback, err := server.Authorize(l.authorizationURL, oidctest.Login{Subject: "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := callback.Query().Get("code")
+
+	//the provider drops the code after a failed exchange too
+	_, err = provider.Exchange(context.Background(), code, "not the verifier", l.nonce)
+	if err == nil {
+		t.Fatal("exchange with the wrong PKCE verifier succeeded")
+	}
+
+	_, err = provider.Exchange(context.Background(), code, l.verifier, l.nonce)
+	if err == nil {
+		t.Fatal("code was accepted twice")
+	}
+}
+
+func TestExchangeRefusesInvalidIDTokens(t *testing.T) {
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		login oidctest.Login
+	}{
+		{"wrong audience", oidctest.Login{Audience: "someone-else"}},
+		{"wrong issuer", oidctest.Login{Issuer: "https://evil.example.com"}},
+		{"wrong nonce", oidctest.Login{Nonce: "replayed"}},
+		{"expired", oidctest.Login{Expiry: time.Now().Add(-time.Minute)}},
+		{"signed by another key", oidctest.Login{SigningKey: otherKey}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, provider := newFakeProvider(t)
+			l := startLogin(t, provider)
+
+			tt.login.Subject = "1"
+			callback, err := server.Authorize(l.authorizationURL, tt.login)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = provider.Exchange(context.Background(), callback.Query().Get("code"), l.verifier, l.nonce)
+			if !errors.Is(err, oidc.ErrInvalidIDToken) {
+				t.Errorf("got %v; want ErrInvalidIDToken", err)
+			}
+		})
+	}
+}
+
+func TestDiscoveryIssuerMustMatch(t *testing.T) {
+	server, _ := newFakeProvider(t)
+
+	provider := oidc.NewProvider(oidc.Config{
+		Name:     "fake",
+		Issuer:   server.Issuer() + "/",
+		ClientID: "client",
+	})
+
+	_, err := provider.AuthCodeURL(context.Background(), "state", "nonce", "verifier")
+	if err == nil {
+		t.Error("a discovery document for another issuer was accepted")
+	}
+}
+
+func TestParseConfig(t *testing.T) {
+	config, err := oidc.ParseConfig("name=google,issuer=https://accounts.google.com,client-id=ID,client-secret=SECRET,scopes=openid email")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Name != "google" || config.Issuer != "https://accounts.google.com" || config.ClientID != "ID" ||
+		config.ClientSecret != "SECRET" || len(config.Scopes) != 2 {
+		t.Errorf("got %+v", config)
+	}
+
+	for _, value := range []string{
+		"name=google,issuer=https://accounts.google.com",
+		"name=google,issuer=https://accounts.google.com,client-id=ID,colour=blue",
+		"name=google,https://accounts.google.com",
+	} {
+		_, err := oidc.ParseConfig(value)
+		if err == nil {
+			t.Errorf("%q was accepted", value)
+		}
+	}
+}
+
+// the url parameters the client sends the user to the provider with
+func TestAuthCodeURL(t *testing.T) {
+	_, provider := newFakeProvider(t)
+	l := startLogin(t, provider)
+
+	u, err := url.Parse(l.authorizationURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := u.Query()
+
+	for name, want := range map[string]string{
+		"state":                 l.state,
+		"nonce":                 l.nonce,
+		"client_id":             "client",
+		"redirect_uri":          redirectURL,
+		"code_challenge_method": "S256",
+		"scope":                 "openid email profile",
+	} {
+		if got := query.Get(name); got != want {
+			t.Errorf("%s: got %q; want %q", name, got, want)
+		}
+	}
+	//only the challenge leaves the client, never the verifier
+	if query.Get("code_challenge") == "" || query.Get("code_challenge") == l.verifier {
+		t.Errorf("bad code_challenge %q", query.Get("code_challenge"))
+	}
+}
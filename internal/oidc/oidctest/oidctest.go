@@ -0,0 +1,257 @@
+/*
+Package oidctest is a fake OpenID Connect provider for tests. It serves the
+discovery document, the key set and the token endpoint over httptest, and
+stands in for the user logging in at the provider with Authorize
+*/
+package oidctest
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const keyID = "oidctest"
+
+// who logs in at the provider, the zero values of the overrides give a valid ID token
+type Login struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+
+	//overrides to get tokens the client must refuse
+	Issuer     string
+	Audience   string
+	Nonce      string
+	Expiry     time.Time
+	SigningKey *rsa.PrivateKey
+}
+
+// an authorization code waiting to be exchanged
+type grant struct {
+	login       Login
+	nonce       string
+	challenge   string
+	redirectURI string
+}
+
+type Server struct {
+	*httptest.Server
+	ClientID     string
+	ClientSecret string
+
+	key *rsa.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]*grant
+}
+
+// start a provider for the client, close it when the test is done
+func NewServer(clientID, clientSecret string) (*Server, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		key:          key,
+		codes:        make(map[string]*grant),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", s.discoveryHandler)
+	mux.HandleFunc("GET /keys", s.keysHandler)
+	mux.HandleFunc("POST /token", s.tokenHandler)
+	s.Server = httptest.NewServer(mux)
+	return s, nil
+}
+
+// the issuer the client must be configured with
+func (s *Server) Issuer() string {
+	return s.URL
+}
+
+/*
+play the user logging in at the provider: read the authorization url the
+client sent the user to and return where the provider sends them back, with
+the code and the state
+*/
+func (s *Server) Authorize(authorizationURL string, login Login) (*url.URL, error) {
+	u, err := url.Parse(authorizationURL)
+	if err != nil {
+		return nil, err
+	}
+	query := u.Query()
+
+	switch {
+	case query.Get("response_type") != "code":
+		return nil, errors.New("oidctest: response_type must be code")
+	case query.Get("client_id") != s.ClientID:
+		return nil, errors.New("oidctest: unknown client_id")
+	case query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "":
+		return nil, errors.New("oidctest: missing S256 code challenge")
+	case query.Get("state") == "" || query.Get("nonce") == "":
+		return nil, errors.New("oidctest: missing state or nonce")
+	}
+
+	redirect, err := url.Parse(query.Get("redirect_uri"))
+	if err != nil {
+		return nil, err
+	}
+
+	code := randomString()
+	s.mu.Lock()
+	s.codes[code] = &grant{
+		login:       login,
+		nonce:       query.Get("nonce"),
+		challenge:   query.Get("code_challenge"),
+		redirectURI: query.Get("redirect_uri"),
+	}
+	s.mu.Unlock()
+
+	back := redirect.Query()
+	back.Set("code", code)
+	back.Set("state", query.Get("state"))
+	redirect.RawQuery = back.Encode()
+	return redirect, nil
+}
+
+func (s *Server) discoveryHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{
+		"issuer":                 s.URL,
+		"authorization_endpoint": s.URL + "/authorize",
+		"token_endpoint":         s.URL + "/token",
+		"jwks_uri":               s.URL + "/keys",
+	})
+}
+
+func (s *Server) keysHandler(w http.ResponseWriter, r *http.Request) {
+	public := s.key.PublicKey
+	writeJSON(w, http.StatusOK, map[string]any{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": keyID,
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(public.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes()),
+		}},
+	})
+}
+
+// exchange a code for tokens, checking the client and the PKCE verifier like a real provider
+func (s *Server) tokenHandler(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+
+	code := r.PostForm.Get("code")
+	s.mu.Lock()
+	g, found := s.codes[code]
+	//codes can only be used once
+	delete(s.codes, code)
+	s.mu.Unlock()
+
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	switch {
+	case r.PostForm.Get("grant_type") != "authorization_code" || !found:
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	case r.PostForm.Get("client_id") != s.ClientID || r.PostForm.Get("client_secret") != s.ClientSecret:
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	case r.PostForm.Get("redirect_uri") != g.redirectURI:
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	case base64.RawURLEncoding.EncodeToString(sum[:]) != g.challenge:
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	idToken, err := s.idToken(g)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"access_token": randomString(),
+		"token_type":   "Bearer",
+		"id_token":     idToken,
+	})
+}
+
+// a signed RS256 ID token for the login, with its overrides applied
+func (s *Server) idToken(g *grant) (string, error) {
+	login := g.login
+	claims := map[string]any{
+		"iss":            s.URL,
+		"aud":            s.ClientID,
+		"sub":            login.Subject,
+		"nonce":          g.nonce,
+		"iat":            time.Now().Unix(),
+		"exp":            time.Now().Add(5 * time.Minute).Unix(),
+		"email":          login.Email,
+		"email_verified": login.EmailVerified,
+		"name":           login.Name,
+	}
+	if login.Issuer != "" {
+		claims["iss"] = login.Issuer
+	}
+	if login.Audience != "" {
+		claims["aud"] = login.Audience
+	}
+	if login.Nonce != "" {
+		claims["nonce"] = login.Nonce
+	}
+	if !login.Expiry.IsZero() {
+		claims["exp"] = login.Expiry.Unix()
+	}
+	key := s.key
+	if login.SigningKey != nil {
+		key = login.SigningKey
+	}
+
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": keyID})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, data any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(data)
+}
+
+func randomString() string {
+	randomBytes := make([]byte, 16)
+	rand.Read(randomBytes)
+	return base64.RawURLEncoding.EncodeToString(randomBytes)
+}
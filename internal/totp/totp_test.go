@@ -0,0 +1,122 @@
+package totp
+
+import (
+	"testing"
+	"time"
+)
+
+// the RFC 6238 appendix B secret, "12345678901234567890" in base32
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// the SHA1 rows of RFC 6238 appendix B, cut down to our 6 digits
+var rfcVectors = []struct {
+	unix int64
+	code string
+}{
+	{59, "287082"},
+	{1111111109, "081804"},
+	{1111111111, "050471"},
+	{1234567890, "005924"},
+	{2000000000, "279037"},
+	{20000000000, "353130"},
+}
+
+func TestValidateRFC6238Vectors(t *testing.T) {
+	for _, tt := range rfcVectors {
+		at := time.Unix(tt.unix, 0)
+
+		step, ok := Validate(rfcSecret, tt.code, at)
+		if !ok {
+			t.Errorf("%d: code %s was refused", tt.unix, tt.code)
+			continue
+		}
+		if want := tt.unix / 30; step != want {
+			t.Errorf("%d: got step %d; want %d", tt.unix, step, want)
+		}
+	}
+}
+
+func TestValidateSkew(t *testing.T) {
+	//the code of the step starting at 1111111110 (step 37037037)
+	const code = "050471"
+	stepStart := time.Unix(1111111110, 0)
+
+	tests := []struct {
+		name string
+		at   time.Time
+		want bool
+	}{
+		{"same step", stepStart, true},
+		{"end of the same step", stepStart.Add(29 * time.Second), true},
+		{"one step later", stepStart.Add(30 * time.Second), true},
+		{"one step earlier", stepStart.Add(-30 * time.Second), true},
+		{"two steps later", stepStart.Add(60 * time.Second), false},
+		{"two steps earlier", stepStart.Add(-31 * time.Second), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := Validate(rfcSecret, code, tt.at)
+			if ok != tt.want {
+				t.Fatalf("got %t; want %t", ok, tt.want)
+			}
+			//the step is the one the code belongs to, not the current one
+			if ok && step != 37037037 {
+				t.Errorf("got step %d; want 37037037", step)
+			}
+		})
+	}
+}
+
+func TestValidateRejects(t *testing.T) {
+	at := time.Unix(59, 0)
+
+	tests := []struct {
+		name   string
+		secret string
+		code   string
+	}{
+		{"wrong code", rfcSecret, "287083"},
+		{"short code", rfcSecret, "28708"},
+		{"long code", rfcSecret, "2870820"},
+		{"empty code", rfcSecret, ""},
+		{"secret that is not base32", "not base32!", "287082"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, ok := Validate(tt.secret, tt.code, at); ok {
+				t.Error("code was accepted")
+			}
+		})
+	}
+}
+
+func TestValidateNormalisesSecret(t *testing.T) {
+	//people copy secrets by hand, in lower case and with stray spaces
+	if _, ok := Validate(" gezdgnbvgy3tqojqgezdgnbvgy3tqojq ", "287082", time.Unix(59, 0)); !ok {
+		t.Error("code was refused")
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := encoding.DecodeString(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(key) != 20 {
+		t.Errorf("got a %d byte key; want 20", len(key))
+	}
+
+	//a secret we made must work with the codes made from it
+	now := time.Now()
+	code := generate(key, now.Unix()/30)
+	if _, ok := Validate(secret, code, now); !ok {
+		t.Error("code for a generated secret was refused")
+	}
+}
@@ -0,0 +1,4 @@
+DELETE FROM permissions 
+WHERE code
+IN
+    ('reading_list:admin', 'reviews:admin');
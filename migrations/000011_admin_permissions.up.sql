@@ -0,0 +1,4 @@
+INSERT INTO permissions (code)
+VALUES 
+    ('reading_list:admin'),
+    ('reviews:admin');
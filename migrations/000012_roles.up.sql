@@ -0,0 +1,54 @@
+CREATE TABLE IF NOT EXISTS roles (
+    id bigserial PRIMARY KEY,
+    name text UNIQUE NOT NULL,
+    created_at timestamp(0) WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS roles_permissions (
+    role_id bigint NOT NULL REFERENCES roles ON DELETE CASCADE,
+    permission_id bigint NOT NULL REFERENCES permissions ON DELETE CASCADE,
+    PRIMARY KEY (role_id, permission_id)
+);
+
+CREATE TABLE IF NOT EXISTS users_roles (
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    role_id bigint NOT NULL REFERENCES roles ON DELETE CASCADE,
+    PRIMARY KEY (user_id, role_id)
+);
+
+INSERT INTO roles (name)
+VALUES 
+    ('reader'),
+    ('contributor'),
+    ('librarian'),
+    ('admin');
+
+-- reader: the default grant of 000009, reading reviews
+INSERT INTO roles_permissions
+SELECT (SELECT id FROM roles WHERE name = 'reader'), id
+FROM permissions
+WHERE code = 'reviews:read';
+
+-- contributor: read access to everything plus writing reviews and reading lists
+INSERT INTO roles_permissions
+SELECT (SELECT id FROM roles WHERE name = 'contributor'), id
+FROM permissions
+WHERE code IN ('books:read', 'reviews:read', 'reading_list:read', 'users:read',
+    'reviews:write', 'reading_list:write');
+
+-- librarian: contributor plus managing the book catalogue
+INSERT INTO roles_permissions
+SELECT (SELECT id FROM roles WHERE name = 'librarian'), id
+FROM permissions
+WHERE code IN ('books:read', 'reviews:read', 'reading_list:read', 'users:read',
+    'reviews:write', 'reading_list:write', 'books:write');
+
+-- admin: every permission
+INSERT INTO roles_permissions
+SELECT (SELECT id FROM roles WHERE name = 'admin'), id
+FROM permissions;
+
+-- existing users keep the read access granted to them in 000009
+INSERT INTO users_roles
+SELECT id, (SELECT id FROM roles WHERE name = 'reader')
+FROM users;
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS permissions_audit (
+    id bigserial PRIMARY KEY,
+    actor_id bigint REFERENCES users ON DELETE SET NULL,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    action text NOT NULL CHECK (action IN ('grant', 'revoke')),
+    codes text[] NOT NULL,
+    created_at timestamp(0) WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
@@ -0,0 +1,5 @@
+ALTER TABLE tokens DROP COLUMN IF EXISTS user_agent;
+
+ALTER TABLE tokens DROP COLUMN IF EXISTS last_used_at;
+
+ALTER TABLE tokens DROP COLUMN IF EXISTS created_at;
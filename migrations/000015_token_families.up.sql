@@ -0,0 +1,6 @@
+-- tokens issued from the same login share a family so a replayed refresh token can revoke all of them
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS family text;
+
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS used_at timestamp(0) WITH TIME ZONE;
+
+CREATE INDEX IF NOT EXISTS tokens_family_idx ON tokens (family);
@@ -0,0 +1,2 @@
+-- the new address waits here until the user confirms it with the emailed token
+ALTER TABLE users ADD COLUMN IF NOT EXISTS pending_email citext;
@@ -0,0 +1,5 @@
+ALTER TABLE users DROP COLUMN IF EXISTS preferences;
+
+ALTER TABLE users DROP COLUMN IF EXISTS avatar_url;
+
+ALTER TABLE users DROP COLUMN IF EXISTS bio;
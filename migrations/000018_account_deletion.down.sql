@@ -0,0 +1,5 @@
+ALTER TABLE reading_lists DROP CONSTRAINT IF EXISTS reading_lists_created_by_fkey;
+ALTER TABLE reading_lists ADD CONSTRAINT reading_lists_created_by_fkey
+    FOREIGN KEY (created_by) REFERENCES users(id) ON DELETE SET NULL;
+
+ALTER TABLE users DROP COLUMN IF EXISTS deletion_scheduled_at;
@@ -0,0 +1,8 @@
+-- accounts wait here until their grace period is over, logging in again cancels the deletion
+ALTER TABLE users ADD COLUMN IF NOT EXISTS deletion_scheduled_at timestamp(0) WITH TIME ZONE;
+
+-- reading lists are personal so they are deleted along with their owner instead of being left
+-- without one, reviews already cascade (reviews.user_id is ON DELETE CASCADE)
+ALTER TABLE reading_lists DROP CONSTRAINT IF EXISTS reading_lists_created_by_fkey;
+ALTER TABLE reading_lists ADD CONSTRAINT reading_lists_created_by_fkey
+    FOREIGN KEY (created_by) REFERENCES users(id) ON DELETE CASCADE;
@@ -0,0 +1,3 @@
+-- failed logins since the last successful one, and when the account may try again
+ALTER TABLE users ADD COLUMN IF NOT EXISTS failed_logins integer NOT NULL DEFAULT 0;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS locked_until timestamp WITH TIME ZONE;
@@ -0,0 +1,15 @@
+-- long-lived keys for scripts, each limited to a subset of the permissions of its owner
+-- only the hash of a key is stored, the prefix is kept so owners can tell their keys apart
+CREATE TABLE IF NOT EXISTS api_keys (
+    id bigserial PRIMARY KEY,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    name text NOT NULL,
+    prefix text NOT NULL,
+    hash bytea NOT NULL UNIQUE,
+    permissions text[] NOT NULL,
+    created_at timestamp(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    last_used_at timestamp(0) WITH TIME ZONE,
+    expiry timestamp(0) WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS api_keys_user_id_idx ON api_keys(user_id);
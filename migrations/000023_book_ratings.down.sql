@@ -0,0 +1,5 @@
+DROP FUNCTION IF EXISTS recompute_book_ratings();
+DROP FUNCTION IF EXISTS star_histogram(numeric[]);
+DROP FUNCTION IF EXISTS star_bucket(numeric);
+ALTER TABLE books DROP COLUMN IF EXISTS rating_histogram;
+ALTER TABLE books DROP COLUMN IF EXISTS rating_count;
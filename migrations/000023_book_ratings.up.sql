@@ -0,0 +1,46 @@
+-- how many reviews a book has and how many gave it 1 to 5 stars, kept in step with average_rating
+ALTER TABLE books ADD COLUMN IF NOT EXISTS rating_count integer NOT NULL DEFAULT 0;
+ALTER TABLE books ADD COLUMN IF NOT EXISTS rating_histogram integer[] NOT NULL DEFAULT '{0,0,0,0,0}';
+
+-- the histogram bucket (1 to 5) a rating is counted in, this is the only place that decides it.
+-- ratings are rounded half up to whole stars and anything under one star (a 0 rating) is clamped
+-- into the 1 star bucket, so the buckets always add up to rating_count
+CREATE OR REPLACE FUNCTION star_bucket(rating numeric) RETURNS integer
+LANGUAGE sql IMMUTABLE
+AS $$
+    SELECT LEAST(GREATEST(ROUND(rating), 1), 5)::integer
+$$;
+
+-- the 1 to 5 star histogram of a set of ratings, NULL entries are not counted
+CREATE OR REPLACE FUNCTION star_histogram(ratings numeric[]) RETURNS integer[]
+LANGUAGE sql IMMUTABLE
+AS $$
+    SELECT ARRAY(
+        SELECT (SELECT COUNT(*) FROM unnest(ratings) AS r(rating) WHERE star_bucket(rating) = star)::integer
+        FROM generate_series(1, 5) AS s(star)
+        ORDER BY star
+    )
+$$;
+
+-- recompute the rating columns of every book from all its reviews, for migrations that change reviews in bulk
+CREATE OR REPLACE FUNCTION recompute_book_ratings() RETURNS void
+LANGUAGE sql
+AS $$
+    UPDATE books
+    SET average_rating = COALESCE(agg.average, 0),
+        rating_count = agg.total,
+        rating_histogram = agg.histogram
+    FROM (
+        SELECT books.id AS book_id,
+            ROUND(AVG(reviews.rating), 2) AS average,
+            COUNT(reviews.rating)::integer AS total,
+            star_histogram(array_agg(reviews.rating::numeric)) AS histogram
+        FROM books
+        LEFT JOIN reviews ON reviews.book_id = books.id
+        GROUP BY books.id
+    ) AS agg
+    WHERE books.id = agg.book_id
+$$;
+
+-- nothing maintained the average before, so compute everything from the existing reviews
+SELECT recompute_book_ratings();
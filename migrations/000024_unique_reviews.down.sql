@@ -0,0 +1,14 @@
+ALTER TABLE reviews DROP CONSTRAINT IF EXISTS reviews_book_user_key;
+
+-- put back the duplicates the up migration removed, unless their book or user is gone by now
+INSERT INTO reviews
+SELECT backup.*
+FROM reviews_duplicates_backup backup
+WHERE EXISTS (SELECT 1 FROM books WHERE books.id = backup.book_id)
+AND EXISTS (SELECT 1 FROM users WHERE users.id = backup.user_id)
+ON CONFLICT (id) DO NOTHING;
+
+DROP TABLE IF EXISTS reviews_duplicates_backup;
+
+-- the restored reviews count towards the ratings again
+SELECT recompute_book_ratings();
@@ -0,0 +1,22 @@
+-- a user reviews a book at most once, keep only the latest of any earlier duplicates.
+-- the older ones are copied to reviews_duplicates_backup first, the down migration puts them back
+CREATE TABLE reviews_duplicates_backup AS
+SELECT older.*
+FROM reviews older
+WHERE EXISTS (
+    SELECT 1 FROM reviews newer
+    WHERE newer.book_id = older.book_id
+    AND newer.user_id = older.user_id
+    AND newer.id > older.id
+);
+
+DELETE FROM reviews older
+USING reviews newer
+WHERE older.book_id = newer.book_id
+AND older.user_id = newer.user_id
+AND older.id < newer.id;
+
+ALTER TABLE reviews ADD CONSTRAINT reviews_book_user_key UNIQUE (book_id, user_id);
+
+-- the deleted duplicates no longer count towards the ratings
+SELECT recompute_book_ratings();
@@ -0,0 +1,2 @@
+ALTER TABLE reviews ALTER COLUMN helpful_count DROP NOT NULL;
+DROP TABLE IF EXISTS review_helpful_votes;
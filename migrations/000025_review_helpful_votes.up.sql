@@ -0,0 +1,13 @@
+-- who found a review helpful, one vote per user, reviews.helpful_count is kept equal to the number of votes
+CREATE TABLE IF NOT EXISTS review_helpful_votes (
+    review_id bigint NOT NULL REFERENCES reviews ON DELETE CASCADE,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    created_at timestamp(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (review_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS review_helpful_votes_user_id_idx ON review_helpful_votes(user_id);
+
+-- there were no votes until now
+UPDATE reviews SET helpful_count = 0 WHERE helpful_count IS DISTINCT FROM 0;
+ALTER TABLE reviews ALTER COLUMN helpful_count SET NOT NULL;
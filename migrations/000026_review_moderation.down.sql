@@ -0,0 +1,6 @@
+DELETE FROM roles WHERE name = 'moderator';
+DELETE FROM permissions WHERE code = 'reviews:moderate';
+DROP TABLE IF EXISTS review_moderation_actions;
+DROP TABLE IF EXISTS review_reports;
+DROP INDEX IF EXISTS reviews_status_idx;
+ALTER TABLE reviews DROP COLUMN IF EXISTS status;
@@ -0,0 +1,49 @@
+-- reviews can be taken out of public view: hidden by a moderator, or pending a decision after being reported
+ALTER TABLE reviews ADD COLUMN IF NOT EXISTS status text NOT NULL DEFAULT 'visible'
+    CHECK (status IN ('visible', 'hidden', 'pending'));
+
+CREATE INDEX IF NOT EXISTS reviews_status_idx ON reviews(status) WHERE status <> 'visible';
+
+-- users reporting reviews, one open report per user and review
+CREATE TABLE IF NOT EXISTS review_reports (
+    id bigserial PRIMARY KEY,
+    review_id bigint NOT NULL REFERENCES reviews ON DELETE CASCADE,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    reason text NOT NULL CHECK (reason IN ('spam', 'offensive', 'off_topic', 'spoiler', 'other')),
+    comment text NOT NULL DEFAULT '',
+    created_at timestamp(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    resolved_at timestamp(0) WITH TIME ZONE
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS review_reports_open_idx ON review_reports(review_id, user_id) WHERE resolved_at IS NULL;
+
+-- what moderators did with a review and why, kept after the moderator account is gone
+CREATE TABLE IF NOT EXISTS review_moderation_actions (
+    id bigserial PRIMARY KEY,
+    review_id bigint NOT NULL REFERENCES reviews ON DELETE CASCADE,
+    moderator_id bigint REFERENCES users ON DELETE SET NULL,
+    action text NOT NULL,
+    status text NOT NULL,
+    note text NOT NULL DEFAULT '',
+    created_at timestamp(0) WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS review_moderation_actions_review_id_idx ON review_moderation_actions(review_id);
+
+INSERT INTO permissions (code)
+VALUES ('reviews:moderate');
+
+INSERT INTO roles (name)
+VALUES ('moderator');
+
+-- moderator: reader plus the moderation queue
+INSERT INTO roles_permissions
+SELECT (SELECT id FROM roles WHERE name = 'moderator'), id
+FROM permissions
+WHERE code IN ('books:read', 'reviews:read', 'reading_list:read', 'users:read', 'reviews:moderate');
+
+-- admin keeps holding every permission
+INSERT INTO roles_permissions
+SELECT (SELECT id FROM roles WHERE name = 'admin'), id
+FROM permissions
+WHERE code = 'reviews:moderate';
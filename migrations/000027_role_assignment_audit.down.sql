@@ -0,0 +1,7 @@
+DELETE FROM permissions_audit WHERE action IN ('assign_role', 'unassign_role');
+
+ALTER TABLE permissions_audit DROP CONSTRAINT IF EXISTS permissions_audit_action_check;
+ALTER TABLE permissions_audit ADD CONSTRAINT permissions_audit_action_check
+    CHECK (action IN ('grant', 'revoke'));
+
+ALTER TABLE permissions_audit DROP COLUMN IF EXISTS role;
@@ -0,0 +1,5 @@
+ALTER TABLE permissions_audit ADD COLUMN IF NOT EXISTS role text;
+
+ALTER TABLE permissions_audit DROP CONSTRAINT IF EXISTS permissions_audit_action_check;
+ALTER TABLE permissions_audit ADD CONSTRAINT permissions_audit_action_check
+    CHECK (action IN ('grant', 'revoke', 'assign_role', 'unassign_role'));